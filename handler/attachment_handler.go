@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	attachmentBucket         = "chat/"
+	attachmentThumbnailWidth = 240
+	maxAttachmentImageSize   = int64(10 << 20) // 10 MiB
+	maxAttachmentClipSize    = int64(20 << 20) // 20 MiB
+	maxAttachmentClipSeconds = 15.0
+	maxAttachmentsPerMessage = 4
+
+	// maxAttachmentImagePixels bounds the decoded size of an image, which a
+	// small compressed file can make arbitrarily large.
+	maxAttachmentImagePixels = int64(40_000_000)
+
+	// attachmentProcessTimeout bounds each ffprobe and ffmpeg run.
+	attachmentProcessTimeout = 30 * time.Second
+)
+
+// Content types accepted as chat attachments, sniffed from the file contents.
+var attachmentImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+var attachmentClipTypes = map[string]string{
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+type Attachment struct {
+	Type         string  `json:"type"`
+	Url          string  `json:"url"`
+	ThumbnailUrl string  `json:"thumbnail_url"`
+	ContentType  string  `json:"content_type"`
+	Size         int64   `json:"size"`
+	Width        int     `json:"width,omitempty"`
+	Height       int     `json:"height,omitempty"`
+	Duration     float64 `json:"duration,omitempty"`
+}
+
+func HandleAttachmentUpload(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if file.Size > maxAttachmentClipSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment is too large"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(src, maxAttachmentClipSize+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	var attachment *Attachment
+	switch {
+	case attachmentImageTypes[contentType]:
+		if int64(len(data)) > maxAttachmentImageSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+		attachment, err = processImageAttachment(data)
+	case attachmentClipTypes[contentType] != "":
+		if int64(len(data)) > maxAttachmentClipSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Clip is too large"})
+			return
+		}
+		attachment, err = processClipAttachment(data, contentType)
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Unsupported attachment type: %s", contentType)})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	attachment.ContentType = contentType
+	attachment.Size = int64(len(data))
+
+	if err := saveAttachmentRecord(*attachment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// processImageAttachment runs the image through the same resize/encode
+// pipeline as profile images and stores a full size copy and a thumbnail.
+func processImageAttachment(data []byte) (*Attachment, error) {
+	if err := checkImageDimensions(data); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	width := img.Bounds().Dx()
+	if width > imageWidth {
+		width = imageWidth
+	}
+	fullImg, err := resizeAndEncodeImage(img, width)
+	if err != nil {
+		return nil, err
+	}
+	url, err := uploadJPEG(bucket, attachmentBucket, fullImg)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbWidth := attachmentThumbnailWidth
+	if thumbWidth > width {
+		thumbWidth = width
+	}
+	thumbImg, err := resizeAndEncodeImage(img, thumbWidth)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailUrl, err := uploadJPEG(bucket, attachmentBucket+"thumbnails/", thumbImg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Attachment{
+		Type:         "image",
+		Url:          url,
+		ThumbnailUrl: thumbnailUrl,
+		Width:        width,
+		Height:       img.Bounds().Dy() * width / img.Bounds().Dx(),
+	}, nil
+}
+
+// checkImageDimensions reads the image header and rejects images too large to
+// decode, before any pixel is allocated.
+func checkImageDimensions(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return fmt.Errorf("image has no pixels")
+	}
+	if int64(config.Width)*int64(config.Height) > maxAttachmentImagePixels {
+		return fmt.Errorf("image is larger than %d pixels", maxAttachmentImagePixels)
+	}
+	return nil
+}
+
+// processClipAttachment stores a short clip as uploaded and extracts its
+// first frame as thumbnail.
+func processClipAttachment(data []byte, contentType string) (*Attachment, error) {
+	tmpDir, err := ioutil.TempDir("", "attachment")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	uniqueID := uuid.New()
+	ext := attachmentClipTypes[contentType]
+	src := filepath.Join(tmpDir, uniqueID.String()+ext)
+	if err := ioutil.WriteFile(src, data, 0600); err != nil {
+		return nil, err
+	}
+
+	processCtx, cancel := context.WithTimeout(ctx, attachmentProcessTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(processCtx, "ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", src).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe clip: %w", err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clip duration: %w", err)
+	}
+	if duration > maxAttachmentClipSeconds {
+		return nil, fmt.Errorf("clip is longer than %.0f seconds", maxAttachmentClipSeconds)
+	}
+
+	thumbnailPath := filepath.Join(tmpDir, uniqueID.String()+"-thumbnail.jpg")
+	thumbnailCmd := exec.CommandContext(processCtx, "ffmpeg", "-i", src, "-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", attachmentThumbnailWidth), thumbnailPath)
+	if err := thumbnailCmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to create clip thumbnail: %w", err)
+	}
+	thumbData, err := ioutil.ReadFile(thumbnailPath)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailUrl, err := uploadJPEG(bucket, attachmentBucket+"thumbnails/", thumbData)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := fmt.Sprintf("%sclips/%s%s", attachmentBucket, uniqueID, ext)
+	wc := bucket.Object(objectPath).NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return nil, err
+	}
+	if err := wc.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Attachment{
+		Type:         "clip",
+		Url:          fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, objectPath),
+		ThumbnailUrl: thumbnailUrl,
+		Duration:     duration,
+	}, nil
+}
+
+// attachmentRef returns the record HandleAttachmentUpload keeps of the
+// attachment stored at url.
+func attachmentRef(url string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(url))
+	return dbClient.Collection("attachments").Doc(hex.EncodeToString(sum[:]))
+}
+
+func saveAttachmentRecord(attachment Attachment) error {
+	_, err := attachmentRef(attachment.Url).Set(ctx, attachmentsToFirestore([]Attachment{attachment})[0])
+	return err
+}
+
+// validateAttachments checks attachments sent with a chat message. Only files
+// previously stored by HandleAttachmentUpload are accepted, with the type,
+// size and dimensions recorded when they were stored.
+func validateAttachments(attachments []Attachment) error {
+	if len(attachments) > maxAttachmentsPerMessage {
+		return fmt.Errorf("too many attachments: %d", len(attachments))
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	prefix := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, attachmentBucket)
+	refs := make([]*firestore.DocumentRef, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.Type != "image" && attachment.Type != "clip" {
+			return fmt.Errorf("invalid attachment type: %s", attachment.Type)
+		}
+		if !strings.HasPrefix(attachment.Url, prefix) || !strings.HasPrefix(attachment.ThumbnailUrl, prefix) {
+			return fmt.Errorf("invalid attachment url: %s", attachment.Url)
+		}
+		refs = append(refs, attachmentRef(attachment.Url))
+	}
+
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		if !doc.Exists() {
+			return fmt.Errorf("unknown attachment: %s", attachments[i].Url)
+		}
+		stored := attachmentsFromFirestore([]interface{}{doc.Data()})[0]
+		if err := matchAttachment(attachments[i], stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchAttachment reports an error when the declared attachment differs from
+// the one stored.
+func matchAttachment(declared Attachment, stored Attachment) error {
+	if declared != stored {
+		return fmt.Errorf("attachment %s does not match the stored file", declared.Url)
+	}
+	return nil
+}
+
+func attachmentsToFirestore(attachments []Attachment) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(attachments))
+	for _, attachment := range attachments {
+		data = append(data, map[string]interface{}{
+			"type":         attachment.Type,
+			"url":          attachment.Url,
+			"thumbnailUrl": attachment.ThumbnailUrl,
+			"contentType":  attachment.ContentType,
+			"size":         attachment.Size,
+			"width":        attachment.Width,
+			"height":       attachment.Height,
+			"duration":     attachment.Duration,
+		})
+	}
+	return data
+}
+
+func attachmentsFromFirestore(value interface{}) []Attachment {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	attachments := make([]Attachment, 0, len(items))
+	for _, item := range items {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		size, _ := data["size"].(int64)
+		width, _ := data["width"].(int64)
+		height, _ := data["height"].(int64)
+		attachment := Attachment{
+			Size:   size,
+			Width:  int(width),
+			Height: int(height),
+		}
+		attachment.Type, _ = data["type"].(string)
+		attachment.Url, _ = data["url"].(string)
+		attachment.ThumbnailUrl, _ = data["thumbnailUrl"].(string)
+		attachment.ContentType, _ = data["contentType"].(string)
+		attachment.Duration, _ = data["duration"].(float64)
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader returns the signature and IHDR chunk of a PNG claiming the given
+// dimensions, without any pixel data.
+func pngHeader(width uint32, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // truecolor
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestCheckImageDimensions(t *testing.T) {
+	var small bytes.Buffer
+	if err := png.Encode(&small, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkImageDimensions(small.Bytes()); err != nil {
+		t.Errorf("small image rejected: %v", err)
+	}
+
+	// The header alone is enough to reject a decompression bomb.
+	if err := checkImageDimensions(pngHeader(100000, 100000)); err == nil {
+		t.Error("100000x100000 image accepted")
+	}
+	if err := checkImageDimensions([]byte("not an image")); err == nil {
+		t.Error("garbage accepted")
+	}
+}
+
+func TestValidateAttachmentsRejectsBeforeLookup(t *testing.T) {
+	prefix := "https://storage.googleapis.com/" + bucketName + "/" + attachmentBucket
+	valid := Attachment{Type: "image", Url: prefix + "a.jpg", ThumbnailUrl: prefix + "thumbnails/a.jpg"}
+
+	tests := map[string][]Attachment{
+		"too many":      {valid, valid, valid, valid, valid},
+		"unknown type":  {{Type: "pdf", Url: valid.Url, ThumbnailUrl: valid.ThumbnailUrl}},
+		"foreign url":   {{Type: "image", Url: "https://example.com/a.jpg", ThumbnailUrl: valid.ThumbnailUrl}},
+		"foreign thumb": {{Type: "image", Url: valid.Url, ThumbnailUrl: "https://example.com/a.jpg"}},
+	}
+	for name, attachments := range tests {
+		if err := validateAttachments(attachments); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if err := validateAttachments(nil); err != nil {
+		t.Errorf("no attachments: %v", err)
+	}
+}
+
+func TestMatchAttachment(t *testing.T) {
+	stored := Attachment{Type: "clip", Url: "u", ThumbnailUrl: "t", ContentType: "video/mp4", Size: 1000, Duration: 3.5}
+	storedRecord := attachmentsFromFirestore([]interface{}{map[string]interface{}{
+		"type": "clip", "url": "u", "thumbnailUrl": "t", "contentType": "video/mp4",
+		"size": int64(1000), "width": int64(0), "height": int64(0), "duration": 3.5,
+	}})[0]
+	if storedRecord != stored {
+		t.Fatalf("record read as %+v", storedRecord)
+	}
+
+	if err := matchAttachment(stored, storedRecord); err != nil {
+		t.Errorf("identical attachment rejected: %v", err)
+	}
+	lied := stored
+	lied.Size = 10
+	if err := matchAttachment(lied, storedRecord); err == nil {
+		t.Error("attachment with another size accepted")
+	}
+	lied = stored
+	lied.ContentType = "image/png"
+	if err := matchAttachment(lied, storedRecord); err == nil {
+		t.Error("attachment with another content type accepted")
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A banned user has banned set on users/{id}. Their profile is hidden and
+// they and their videos are left out of every list other users see, like
+// users who blocked the viewer. Bans are set by admins holding ADMIN_TOKEN.
+
+var (
+	// bannedIdsTTL bounds how long a ban made through another instance takes
+	// to apply.
+	bannedIdsTTL = time.Minute
+
+	bannedIdsLock     sync.Mutex
+	bannedIds         map[string]bool
+	bannedIdsLoadedAt time.Time
+)
+
+// getBannedIds returns the banned users. The set is shared and must not be
+// changed.
+func getBannedIds() (map[string]bool, error) {
+	bannedIdsLock.Lock()
+	defer bannedIdsLock.Unlock()
+
+	if bannedIds != nil && time.Since(bannedIdsLoadedAt) < bannedIdsTTL {
+		return bannedIds, nil
+	}
+	docs, err := dbClient.Collection("users").Where("banned", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		ids[doc.Ref.ID] = true
+	}
+	bannedIds, bannedIdsLoadedAt = ids, time.Now()
+	return bannedIds, nil
+}
+
+func isUserBanned(user *firestore.DocumentSnapshot) bool {
+	banned, _ := user.Data()["banned"].(bool)
+	return banned
+}
+
+// requireAdmin answers 403 unless the request carries ADMIN_TOKEN as a
+// bearer token. Without ADMIN_TOKEN every admin request is refused.
+func requireAdmin(c *gin.Context) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return false
+	}
+	return true
+}
+
+// BanUser and UnbanUser set whether the user is banned.
+func BanUser(c *gin.Context) {
+	setBanned(c, true)
+}
+
+func UnbanUser(c *gin.Context) {
+	setBanned(c, false)
+}
+
+func setBanned(c *gin.Context, banned bool) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	userId := c.Param("id")
+	_, err := dbClient.Collection("users").Doc(userId).Update(ctx, []firestore.Update{
+		{Path: "banned", Value: banned},
+		{Path: "banned_changed_at", Value: firestore.ServerTimestamp},
+	})
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	bannedIdsLock.Lock()
+	bannedIds = nil
+	bannedIdsLock.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"banned": banned})
+}
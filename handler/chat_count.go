@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Videos keep the number of their chat messages in chat_count. Videos from
+// before the counter was kept have no chat_count; their messages are counted
+// once, by the chat_counts migration or by the first message sent to them.
+// Once the migration completed, messages increment chat_count without a
+// transaction.
+
+const chatCountsMigration = "chat_counts"
+
+// countChatInTx returns the chat count of the video read in tx, counting the
+// stored messages when the video has no chat_count yet. ok is false when the
+// video does not exist.
+//
+// The messages are counted after the video document is read, so a message
+// saved concurrently either is in the count or conflicts with the write of
+// chat_count that follows and is retried against the backfilled counter.
+func countChatInTx(tx *firestore.Transaction, videoRef *firestore.DocumentRef) (count int64, backfilled bool, ok bool, err error) {
+	doc, err := tx.Get(videoRef)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return 0, false, false, nil
+		}
+		return 0, false, false, err
+	}
+	if count, present := doc.Data()["chat_count"].(int64); present {
+		return count, false, true, nil
+	}
+
+	stored, err := getMessageDocCount(videoRef.ID)
+	if err != nil {
+		return 0, false, false, err
+	}
+	return int64(stored), true, true, nil
+}
+
+// backfillChatCount sets the chat_count of a video that has none and returns
+// the video's chat count.
+func backfillChatCount(videoId string) (int64, error) {
+	videoRef := dbClient.Collection("videos").Doc(videoId)
+	var count int64
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var backfilled, ok bool
+		var err error
+		count, backfilled, ok, err = countChatInTx(tx, videoRef)
+		if err != nil || !ok || !backfilled {
+			return err
+		}
+		return tx.Set(videoRef, map[string]interface{}{"chat_count": count}, firestore.MergeAll)
+	})
+	return count, err
+}
+
+// migrateChatCounts backfills chat_count on every video that has none.
+func migrateChatCounts() error {
+	docs, err := dbClient.Collection("videos").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, doc := range docs {
+		if _, ok := doc.Data()["chat_count"].(int64); ok {
+			continue
+		}
+		if _, err := backfillChatCount(doc.Ref.ID); err != nil {
+			log.Printf("failed to backfill chat_count of %s: %v", doc.Ref.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d videos were not backfilled", failed)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+
+	"example.com/gobloc/pagination"
+
+	"github.com/gin-gonic/gin"
+)
+
+var searchPageLimits = pagination.DefaultLimits
+
+type MessageSearchResult struct {
+	Message    Message     `json:"message"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// MessageSearchPage is a page of search results, with the number of matches.
+// When Capped is set more messages matched than were looked up, and Total
+// counts only those.
+type MessageSearchPage struct {
+	pagination.Page[MessageSearchResult]
+	Total  int  `json:"total"`
+	Capped bool `json:"capped"`
+}
+
+// getConversationRoomIds returns the rooms the user has joined.
+func getConversationRoomIds(userId string) ([]string, error) {
+	docs, err := dbClient.Collection("read_cursors").Where("userId", "==", userId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	roomIds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		roomIds = append(roomIds, doc.Data()["roomId"].(string))
+	}
+	return roomIds, nil
+}
+
+func SearchMessages(c *gin.Context) {
+	query := c.DefaultQuery("q", "")
+	roomId := c.DefaultQuery("room_id", "")
+	userId := c.DefaultQuery("user_id", "")
+
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is missing"})
+		return
+	}
+	if roomId == "" && userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id or user_id is required"})
+		return
+	}
+
+	scope := "chat_search:" + roomId + ":" + userId + ":" + query
+	req, ok := parsePageRequest(c, scope, searchPageLimits)
+	if !ok {
+		return
+	}
+
+	var err error
+	roomIds := []string{roomId}
+	if roomId == "" {
+		roomIds, err = getConversationRoomIds(userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	hits, total, capped, err := searchMessages(query, roomIds, req.Offset, req.Size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]MessageSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, MessageSearchResult{
+			Message: Message{
+				Id:       hit.Message.Id,
+				UserId:   hit.Message.UserId,
+				Nickname: hit.Message.Nickname,
+				Text:     hit.Message.Text,
+				RoomId:   hit.Message.RoomId,
+				SendTime: formatSendTime(hit.Message.SendTime),
+			},
+			Highlights: hit.Highlights,
+		})
+	}
+
+	nextCursor := ""
+	if req.Offset+len(hits) < total {
+		nextCursor = pagination.AtOffset(scope, req.Offset+len(hits))
+	}
+
+	c.JSON(http.StatusOK, MessageSearchPage{
+		Page:   pagination.NewPage(results, nextCursor),
+		Total:  total,
+		Capped: capped,
+	})
+}
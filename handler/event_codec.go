@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"example.com/gobloc/handler/eventpb"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// eventCodec is the framing of events on a websocket, selected by the
+// negotiated subprotocol.
+type eventCodec interface {
+	encodeEvent(event Event) (messageType int, data []byte, err error)
+	decodeEvent(messageType int, data []byte, event *Event) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) encodeEvent(event Event) (int, []byte, error) {
+	data, err := json.Marshal(event)
+	return websocket.TextMessage, data, err
+}
+
+func (jsonCodec) decodeEvent(messageType int, data []byte, event *Event) error {
+	return json.Unmarshal(data, event)
+}
+
+// protoCodec frames each event as one binary message encoded as described in
+// eventpb/event.proto.
+type protoCodec struct{}
+
+func (protoCodec) encodeEvent(event Event) (int, []byte, error) {
+	data, err := proto.Marshal(eventToProto(event))
+	return websocket.BinaryMessage, data, err
+}
+
+func (protoCodec) decodeEvent(messageType int, data []byte, event *Event) error {
+	if messageType != websocket.BinaryMessage {
+		return fmt.Errorf("expected binary frame, got type %d", messageType)
+	}
+	var pb eventpb.Event
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*event = eventFromProto(&pb)
+	return nil
+}
+
+func eventToProto(event Event) *eventpb.Event {
+	pb := &eventpb.Event{
+		EventType: event.EventType,
+		UserLike:  event.UserLike,
+		UserId:    event.UserId,
+		ReadTime:  event.ReadTime,
+		RequestId: event.RequestId,
+	}
+	if event.Message != nil {
+		pb.Message = messageToProto(*event.Message)
+	}
+	if event.FirstMessage != nil {
+		pb.FirstMessage = messageListToProto(*event.FirstMessage)
+	}
+	if event.TotalLike != nil {
+		pb.TotalLike = proto.Int64(int64(*event.TotalLike))
+	}
+	if event.Notification != nil {
+		n := event.Notification
+		pb.Notification = &eventpb.Notification{
+			Id:           n.Id,
+			UserId:       n.UserId,
+			Type:         n.Type,
+			RoomId:       n.RoomId,
+			FromUserId:   n.FromUserId,
+			FromNickname: n.FromNickname,
+			Text:         n.Text,
+			CreatedAt:    n.CreatedAt,
+			Read:         n.Read,
+		}
+	}
+	if event.Error != nil {
+		pb.Error = &eventpb.EventError{Code: event.Error.Code, Message: event.Error.Message}
+	}
+	if event.ProtocolVersion != nil {
+		pb.ProtocolVersion = proto.Int64(int64(*event.ProtocolVersion))
+	}
+	if event.RoomSettings != nil {
+		pb.RoomSettings = &eventpb.RoomSettings{
+			SlowModeSeconds: int64(event.RoomSettings.SlowModeSeconds),
+			FollowersOnly:   event.RoomSettings.FollowersOnly,
+			ChatDisabled:    event.RoomSettings.ChatDisabled,
+		}
+	}
+	if event.PinnedMessages != nil {
+		pb.PinnedMessages = messageListToProto(*event.PinnedMessages)
+	}
+	return pb
+}
+
+func messageListToProto(messages []Message) *eventpb.MessageList {
+	list := &eventpb.MessageList{}
+	for _, msg := range messages {
+		list.Messages = append(list.Messages, messageToProto(msg))
+	}
+	return list
+}
+
+func messageToProto(msg Message) *eventpb.Message {
+	pb := &eventpb.Message{
+		Id:         msg.Id,
+		UserImage:  msg.UserImage,
+		Username:   msg.UserId,
+		Nickname:   msg.Nickname,
+		Text:       msg.Text,
+		RoomId:     msg.RoomId,
+		TotalCount: int64(msg.TotalCount),
+		SendTime:   msg.SendTime,
+	}
+	for _, mention := range msg.Mentions {
+		pb.Mentions = append(pb.Mentions, &eventpb.Mention{
+			UserId:   mention.UserId,
+			Nickname: mention.Nickname,
+			Offset:   int64(mention.Offset),
+			Length:   int64(mention.Length),
+		})
+	}
+	for _, attachment := range msg.Attachments {
+		pb.Attachments = append(pb.Attachments, &eventpb.Attachment{
+			Type:         attachment.Type,
+			Url:          attachment.Url,
+			ThumbnailUrl: attachment.ThumbnailUrl,
+			ContentType:  attachment.ContentType,
+			Size:         attachment.Size,
+			Width:        int64(attachment.Width),
+			Height:       int64(attachment.Height),
+			Duration:     attachment.Duration,
+		})
+	}
+	return pb
+}
+
+func eventFromProto(pb *eventpb.Event) Event {
+	event := Event{
+		EventType: pb.EventType,
+		UserLike:  pb.UserLike,
+		UserId:    pb.UserId,
+		ReadTime:  pb.ReadTime,
+		RequestId: pb.RequestId,
+	}
+	if pb.Message != nil {
+		msg := messageFromProto(pb.Message)
+		event.Message = &msg
+	}
+	if pb.FirstMessage != nil {
+		messages := messageListFromProto(pb.FirstMessage)
+		event.FirstMessage = &messages
+	}
+	if pb.TotalLike != nil {
+		totalLike := int(*pb.TotalLike)
+		event.TotalLike = &totalLike
+	}
+	if pb.Notification != nil {
+		n := pb.Notification
+		event.Notification = &Notification{
+			Id:           n.Id,
+			UserId:       n.UserId,
+			Type:         n.Type,
+			RoomId:       n.RoomId,
+			FromUserId:   n.FromUserId,
+			FromNickname: n.FromNickname,
+			Text:         n.Text,
+			CreatedAt:    n.CreatedAt,
+			Read:         n.Read,
+		}
+	}
+	if pb.Error != nil {
+		event.Error = &EventError{Code: pb.Error.Code, Message: pb.Error.Message}
+	}
+	if pb.ProtocolVersion != nil {
+		version := int(*pb.ProtocolVersion)
+		event.ProtocolVersion = &version
+	}
+	if pb.RoomSettings != nil {
+		event.RoomSettings = &RoomSettings{
+			SlowModeSeconds: int(pb.RoomSettings.SlowModeSeconds),
+			FollowersOnly:   pb.RoomSettings.FollowersOnly,
+			ChatDisabled:    pb.RoomSettings.ChatDisabled,
+		}
+	}
+	if pb.PinnedMessages != nil {
+		messages := messageListFromProto(pb.PinnedMessages)
+		event.PinnedMessages = &messages
+	}
+	return event
+}
+
+func messageListFromProto(list *eventpb.MessageList) []Message {
+	messages := make([]Message, 0, len(list.Messages))
+	for _, msg := range list.Messages {
+		messages = append(messages, messageFromProto(msg))
+	}
+	return messages
+}
+
+func messageFromProto(pb *eventpb.Message) Message {
+	msg := Message{
+		Id:         pb.Id,
+		UserImage:  pb.UserImage,
+		UserId:     pb.Username,
+		Nickname:   pb.Nickname,
+		Text:       pb.Text,
+		RoomId:     pb.RoomId,
+		TotalCount: int(pb.TotalCount),
+		SendTime:   pb.SendTime,
+	}
+	for _, mention := range pb.Mentions {
+		msg.Mentions = append(msg.Mentions, Mention{
+			UserId:   mention.UserId,
+			Nickname: mention.Nickname,
+			Offset:   int(mention.Offset),
+			Length:   int(mention.Length),
+		})
+	}
+	for _, attachment := range pb.Attachments {
+		msg.Attachments = append(msg.Attachments, Attachment{
+			Type:         attachment.Type,
+			Url:          attachment.Url,
+			ThumbnailUrl: attachment.ThumbnailUrl,
+			ContentType:  attachment.ContentType,
+			Size:         attachment.Size,
+			Width:        int(attachment.Width),
+			Height:       int(attachment.Height),
+			Duration:     attachment.Duration,
+		})
+	}
+	return msg
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/gobloc/handler/eventpb"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func fixtureEvent() Event {
+	totalLike := 0
+	userLike := true
+	userId := "user-1"
+	readTime := "2026-10-19T10:00:00Z"
+	requestId := "req-1"
+	version := protocolV2
+	history := []Message{
+		{
+			Id:         "m1",
+			UserImage:  "https://example.com/a.jpg",
+			UserId:     "user-2",
+			Nickname:   "철수",
+			Text:       "hi @user-1",
+			RoomId:     "room-1",
+			TotalCount: 2,
+			SendTime:   "2026-10-19T09:59:00Z",
+			Mentions:   []Mention{{UserId: "user-1", Nickname: "user-1", Offset: 3, Length: 7}},
+			Attachments: []Attachment{{
+				Type:         "video",
+				Url:          "https://example.com/clip.mp4",
+				ThumbnailUrl: "https://example.com/clip.jpg",
+				ContentType:  "video/mp4",
+				Size:         1 << 20,
+				Width:        1280,
+				Height:       720,
+				Duration:     12.5,
+			}},
+		},
+		{Id: "m2", Text: "second", TotalCount: 2},
+	}
+	pinned := []Message{}
+
+	return Event{
+		EventType:    "first_message",
+		Message:      &history[0],
+		FirstMessage: &history,
+		TotalLike:    &totalLike,
+		UserLike:     &userLike,
+		UserId:       &userId,
+		ReadTime:     &readTime,
+		Notification: &Notification{
+			Id: "n1", UserId: "user-1", Type: "mention", RoomId: "room-1", FromUserId: "user-2",
+			FromNickname: "철수", Text: "hi", CreatedAt: "2026-10-19T09:59:00Z", Read: true,
+		},
+		RequestId:       &requestId,
+		Error:           &EventError{Code: errorSlowMode, Message: "slow mode: wait 3 seconds"},
+		ProtocolVersion: &version,
+		RoomSettings:    &RoomSettings{SlowModeSeconds: 30, FollowersOnly: true, ChatDisabled: true},
+		PinnedMessages:  &pinned,
+	}
+}
+
+func TestEventCodecRoundTrip(t *testing.T) {
+	totalLike := 7
+	events := []Event{
+		fixtureEvent(),
+		{EventType: "total_like", TotalLike: &totalLike},
+		{},
+	}
+	for _, event := range events {
+		messageType, data, err := protoCodec{}.encodeEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Event
+		if err := (protoCodec{}).decodeEvent(messageType, data, &got); err != nil {
+			t.Fatalf("decoding %q: %v", event.EventType, err)
+		}
+		if !reflect.DeepEqual(got, event) {
+			t.Errorf("round trip of %q:\ngot  %+v\nwant %+v", event.EventType, got, event)
+		}
+	}
+}
+
+func TestEventCodecKeepsPresence(t *testing.T) {
+	_, data, err := protoCodec{}.encodeEvent(fixtureEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pb eventpb.Event
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		t.Fatal(err)
+	}
+	if pb.TotalLike == nil || *pb.TotalLike != 0 {
+		t.Errorf("total_like = %v, want a present zero", pb.TotalLike)
+	}
+	if pb.PinnedMessages == nil || len(pb.PinnedMessages.Messages) != 0 {
+		t.Errorf("pinned_messages = %v, want a present empty list", pb.PinnedMessages)
+	}
+	if pb.Message.Username != "user-2" {
+		t.Errorf("message.username = %q, want the sender's user id", pb.Message.Username)
+	}
+}
+
+func TestEventCodecRejectsMalformedInput(t *testing.T) {
+	_, valid, err := protoCodec{}.encodeEvent(fixtureEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var badLength []byte
+	badLength = protowire.AppendTag(badLength, 1, protowire.BytesType)
+	badLength = protowire.AppendVarint(badLength, 100)
+	badLength = append(badLength, "short"...)
+
+	var badNested []byte
+	badNested = protowire.AppendTag(badNested, 2, protowire.BytesType)
+	badNested = protowire.AppendBytes(badNested, []byte{0xff})
+
+	inputs := map[string][]byte{
+		"truncated":               valid[:len(valid)-1],
+		"length past end":         badLength,
+		"field number zero":       {0x00, 0x00},
+		"bad nested message":      badNested,
+		"end group without start": protowire.AppendTag(nil, 1, protowire.EndGroupType),
+	}
+	for name, data := range inputs {
+		var event Event
+		if err := (protoCodec{}).decodeEvent(websocket.BinaryMessage, data, &event); err == nil {
+			t.Errorf("%s: decoded without error: %+v", name, event)
+		}
+	}
+
+	var event Event
+	if err := (protoCodec{}).decodeEvent(websocket.TextMessage, valid, &event); err == nil {
+		t.Error("text frame decoded without error")
+	}
+}
+
+func TestEventCodecSkipsUnknownFields(t *testing.T) {
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, "message")
+	data = protowire.AppendTag(data, 99, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	data = protowire.AppendTag(data, 100, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 1)
+
+	var event Event
+	if err := (protoCodec{}).decodeEvent(websocket.BinaryMessage, data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "message" {
+		t.Errorf("event_type = %q, want message", event.EventType)
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var feedPageLimits = pagination.Limits{Default: 10, Max: 30}
+
+// GetFollowingFeed lists recent uploads of the creators the user follows,
+// newest first. The feed is merged on read: one query per group of followed
+// creators, each starting after the last video of the previous page.
+func GetFollowingFeed(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	scope := "feed_following:" + userId
+	req, ok := parsePageRequest(c, scope, feedPageLimits)
+	if !ok {
+		return
+	}
+
+	videos, lastVideoId, err := getFollowingFeed(userId, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch feed")
+		return
+	}
+
+	nextCursor := ""
+	if lastVideoId != "" {
+		nextCursor = pagination.After(scope, lastVideoId)
+	}
+	c.JSON(http.StatusOK, pagination.NewPage(videos, nextCursor))
+}
+
+// getFollowingFeed returns a page of the feed and, when more may follow, the
+// id of the last video considered for it.
+func getFollowingFeed(userId string, req pagination.Request) ([]Video, string, error) {
+	blockedIds, err := getBlockedIds(userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	followDocs, err := dbClient.Collection("follows").Where("follower_id", "==", userId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	legacy, err := legacyFollowIds(userId, true)
+	if err != nil {
+		return nil, "", err
+	}
+	var creatorIds []string
+	for _, doc := range followDocs {
+		creatorId := doc.Data()["following_id"].(string)
+		delete(legacy, creatorId)
+		if !blockedIds[creatorId] {
+			creatorIds = append(creatorIds, creatorId)
+		}
+	}
+	for creatorId := range legacy {
+		if !blockedIds[creatorId] {
+			creatorIds = append(creatorIds, creatorId)
+		}
+	}
+
+	videos := []Video{}
+	if len(creatorIds) == 0 {
+		return videos, "", nil
+	}
+
+	cursor, err := cursorDoc("videos", req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Firestore limits "in" filters to 10 values.
+	var docs []*firestore.DocumentSnapshot
+	hasMore := false
+	for start := 0; start < len(creatorIds); start += 10 {
+		end := start + 10
+		if end > len(creatorIds) {
+			end = len(creatorIds)
+		}
+
+		query := dbClient.Collection("videos").
+			Where("uploader", "in", creatorIds[start:end]).
+			OrderBy("upload_time", firestore.Desc).
+			Limit(req.Size)
+		if cursor != nil {
+			query = query.StartAfter(cursor)
+		}
+
+		chunk, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(chunk) == req.Size {
+			hasMore = true
+		}
+		docs = append(docs, chunk...)
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Data()["upload_time"].(time.Time).After(docs[j].Data()["upload_time"].(time.Time))
+	})
+	if len(docs) > req.Size {
+		docs = docs[:req.Size]
+		hasMore = true
+	}
+
+	videoIds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		videoIds = append(videoIds, doc.Ref.ID)
+	}
+	found, err := loadVideosByIds(videoIds)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, id := range videoIds {
+		if video, ok := found[id]; ok && !blockedIds[id] {
+			videos = append(videos, video)
+		}
+	}
+
+	if err := attachVideoStats(videos, userId); err != nil {
+		return nil, "", err
+	}
+
+	lastVideoId := ""
+	if hasMore && len(docs) > 0 {
+		lastVideoId = docs[len(docs)-1].Ref.ID
+	}
+	return videos, lastVideoId, nil
+}
+
+// getBlockedIds returns the ids the user blocked, which may be videos or
+// users, together with the users who blocked them and the banned users.
+func getBlockedIds(userId string) (map[string]bool, error) {
+	blockedIds := make(map[string]bool)
+
+	banned, err := getBannedIds()
+	if err != nil {
+		return nil, err
+	}
+	for id := range banned {
+		blockedIds[id] = true
+	}
+
+	blocked, err := getBlockedVideos(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blocked {
+		blockedIds[id] = true
+	}
+
+	blockers, err := dbClient.Collection("blocklist").Where("blockedId", "==", userId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range blockers {
+		blockedIds[doc.Data()["userId"].(string)] = true
+	}
+	return blockedIds, nil
+}
@@ -2,8 +2,14 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -13,193 +19,252 @@ type FollowInfo struct {
 	FollowingCount int64    `json:"following_count"`
 	FollowerCount  int64    `json:"follower_count"`
 	TotalLikes     int64    `json:"total_likes"`
+	VideoCount     int64    `json:"video_count"`
 	UserInfo       UserInfo `json:"user_info"`
 }
 
 func GetFollowingUsersInfo(c *gin.Context) {
 	userId := c.DefaultQuery("user_id", "")
 
-	followingUsersInfo, err := getFollowingUsersInfo(ctx, userId)
+	scope := "follow:" + userId
+	req, ok := parsePageRequest(c, scope, followPageLimits)
+	if !ok {
+		return
+	}
+
+	followDocs, nextCursor, err := getFollowDocs("follower_id", userId, scope, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch following users")
+		return
+	}
+
+	followingUsersInfo, err := getFollowingUsersInfo(ctx, followDocs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, followingUsersInfo)
+	c.JSON(http.StatusOK, pagination.NewPage(followingUsersInfo, nextCursor))
 }
 
-func getFollowingUsersInfo(ctx context.Context, userId string) ([]FollowInfo, error) {
-	followingsRef := dbClient.Collection("followings").Doc(userId)
-	followingsSnapshot, err := followingsRef.Get(ctx)
+// getFollowingUsersInfo returns the followed users of the follows records
+// with their stats, reading the users and their stats records in batches.
+func getFollowingUsersInfo(ctx context.Context, followDocs []*firestore.DocumentSnapshot) ([]FollowInfo, error) {
+	followingIds := make([]string, 0, len(followDocs))
+	for _, doc := range followDocs {
+		followingIds = append(followingIds, doc.Data()["following_id"].(string))
+	}
 
-	var followingIds []interface{}
+	followingUsersInfo := make([]FollowInfo, 0)
+	if len(followingIds) == 0 {
+		return followingUsersInfo, nil
+	}
 
+	userInfos, err := getUserInfos(followingIds)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := getUserStats(followingIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, followingId := range followingIds {
+		userInfo, ok := userInfos[followingId]
+		if !ok {
+			continue
+		}
+
+		userStats := stats[followingId]
+		followInfo := FollowInfo{
+			FollowingCount: userStats.FollowingCount,
+			FollowerCount:  userStats.FollowerCount,
+			TotalLikes:     userStats.TotalLikes,
+			VideoCount:     userStats.VideoCount,
+			UserInfo:       userInfo,
+		}
+
+		followingUsersInfo = append(followingUsersInfo, followInfo)
+	}
+
+	return followingUsersInfo, nil
+}
+
+// Follows are stored as one record per edge in the follows collection, keyed
+// by follower and target, and counted in both users' stats.
+func followRef(followerId string, followingId string) *firestore.DocumentRef {
+	return dbClient.Collection("follows").Doc(followerId + "_" + followingId)
+}
+
+// isUserFollowing reports whether followerId follows followingId.
+func isUserFollowing(ctx context.Context, followerId, followingId string) (bool, error) {
+	_, err := followRef(followerId, followingId).Get(ctx)
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
-			followingIds = []interface{}{}
-		} else {
-			return nil, err
+			return isLegacyFollowing(followerId, followingId)
 		}
-	} else {
-		followingIds = followingsSnapshot.Data()["followingIds"].([]interface{})
+		return false, err
 	}
+	return true, nil
+}
 
-	followingUsersInfo := make([]FollowInfo, 0)
+var (
+	errSelfFollow    = errors.New("cannot follow yourself")
+	errFollowBlocked = errors.New("cannot follow a blocked user")
+	errUserNotFound  = errors.New("user not found")
+)
 
-	for _, followingId := range followingIds {
-		followingUserId := followingId.(string)
-		followingUserRef := dbClient.Collection("users").Doc(followingUserId)
-		followingUserSnapshot, err := followingUserRef.Get(ctx)
-		if err != nil {
-			return nil, err
+// ToggleFollow follows the creator if the user does not follow them yet, and
+// unfollows otherwise.
+func ToggleFollow(c *gin.Context) {
+	followerId := c.PostForm("user_id")
+	followingId := c.PostForm("creator")
+
+	respondFollow(c, followerId, followingId, func(current bool) bool { return !current })
+}
+
+// FollowUser and UnfollowUser set the follow state directly, so repeating
+// either request is harmless.
+func FollowUser(c *gin.Context) {
+	respondFollow(c, c.Param("id"), c.Param("targetID"), func(bool) bool { return true })
+}
+
+func UnfollowUser(c *gin.Context) {
+	respondFollow(c, c.Param("id"), c.Param("targetID"), func(bool) bool { return false })
+}
+
+func respondFollow(c *gin.Context, followerId string, followingId string, desired func(current bool) bool) {
+	following, err := updateFollow(followerId, followingId, desired)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"following": following})
+	case errSelfFollow:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errFollowBlocked:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errUserNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// updateFollow brings the follow from followerId to followingId into the
+// desired state. The follow record and both users' counters are written in
+// one transaction, so they never disagree. Following is refused when either
+// user blocked the other; unfollowing is always allowed.
+func updateFollow(followerId string, followingId string, desired func(current bool) bool) (bool, error) {
+	if followerId == "" || followingId == "" {
+		return false, errUserNotFound
+	}
+	if followerId == followingId {
+		return false, errSelfFollow
+	}
+
+	blocked, err := isBlockedPair(followerId, followingId)
+	if err != nil {
+		return false, err
+	}
+
+	edgeRef := followRef(followerId, followingId)
+	targetRef := dbClient.Collection("users").Doc(followingId)
+
+	var following bool
+	var changed int64
+	err = dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		changed = 0
+
+		edgeDoc, err := tx.Get(edgeRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
 		}
-		followingUserInfo := followingUserSnapshot.Data()
-		userInfo := UserInfo{
-			Id:        followingUserSnapshot.Ref.ID,
-			Image:     followingUserInfo["image"].(string),
-			Thumbnail: followingUserInfo["thumbnail"].(string),
-			Nickname:  followingUserInfo["nickname"].(string),
-			Intro:     followingUserInfo["introduction"].(string),
+		if _, err := tx.Get(targetRef); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errUserNotFound
+			}
+			return err
 		}
-
-		followersRef := dbClient.Collection("followers").Doc(followingUserId)
-		followersSnapshot, err := followersRef.Get(ctx)
-		followerCount := int64(0)
-		if err != nil {
-			if status.Code(err) != codes.NotFound {
-				return nil, err
+		var legacy []legacyFollowEdge
+		if !edgeDoc.Exists() {
+			if legacy, err = legacyFollowInTx(tx, followerId, followingId); err != nil {
+				return err
 			}
-		} else {
-			followerCount = int64(len(followersSnapshot.Data()["followerIds"].([]interface{})))
 		}
 
-		followingsRef := dbClient.Collection("followings").Doc(followingUserId)
-		followingsSnapshot, err := followingsRef.Get(ctx)
-		followingCount := int64(0)
-		if err != nil {
-			if status.Code(err) != codes.NotFound {
-				return nil, err
+		current := edgeDoc.Exists() || len(legacy) > 0
+		following = desired(current)
+		if following == current {
+			return nil
+		}
+		if following && blocked {
+			return errFollowBlocked
+		}
+
+		if !following && len(legacy) > 0 {
+			// Legacy follows are not counted in user_stats yet.
+			for _, edge := range legacy {
+				if err := tx.Update(edge.ref, []firestore.Update{
+					{Path: edge.field, Value: firestore.ArrayRemove(edge.id)},
+				}); err != nil {
+					return err
+				}
 			}
-		} else {
-			followingCount = int64(len(followingsSnapshot.Data()["followingIds"].([]interface{})))
+			return nil
 		}
 
-		videosRef := dbClient.Collection("videos").Where("uploader", "==", followingUserId)
-		videosSnapshot, err := videosRef.Documents(ctx).GetAll()
-		if err != nil {
-			return nil, err
+		delta := int64(1)
+		if following {
+			err = tx.Create(edgeRef, map[string]interface{}{
+				"follower_id":  followerId,
+				"following_id": followingId,
+				"created_at":   time.Now(),
+			})
+		} else {
+			delta = -1
+			err = tx.Delete(edgeRef)
 		}
-		totalLikes := int64(0)
-		for _, doc := range videosSnapshot {
-			likeCount := doc.Data()["like_count"].(int64)
-			totalLikes += likeCount
+		if err != nil {
+			return err
 		}
 
-		followInfo := FollowInfo{
-			FollowingCount: followingCount,
-			FollowerCount:  followerCount,
-			TotalLikes:     totalLikes,
-			UserInfo:       userInfo,
+		if err := incrementUserStat(tx, followerId, "following_count", delta); err != nil {
+			return err
 		}
+		changed = delta
+		return incrementUserStat(tx, followingId, "follower_count", delta)
+	})
+	if err != nil {
+		return false, err
+	}
 
-		followingUsersInfo = append(followingUsersInfo, followInfo)
+	if changed != 0 {
+		if err := searchIndex.AddPopularity(searchKindUser, followingId, float64(changed)); err != nil {
+			log.Printf("failed to update search popularity of %s: %v", followingId, err)
+		}
 	}
 
-	return followingUsersInfo, nil
+	return following, nil
+}
+
+// isBlockedPair reports whether either user blocked the other.
+func isBlockedPair(userId string, otherId string) (bool, error) {
+	for _, pair := range [][2]string{{userId, otherId}, {otherId, userId}} {
+		blocked, err := hasBlocked(pair[0], pair[1])
+		if err != nil || blocked {
+			return blocked, err
+		}
+	}
+	return false, nil
 }
 
-// func ToggleFollow(c *gin.Context) {
-// 	followerId := c.PostForm("user_id") // PostForm 메소드를 사용하여 폼 데이터에서 userId를 추출합니다.
-// 	followingId := c.PostForm("creator")
-
-// 	if followerId == followingId {
-// 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot follow yourself"})
-// 		return
-// 	}
-
-// 	// 팔로우 상태 확인
-// 	isFollowing, err := isUserFollowing(ctx, followerId, followingId)
-// 	if err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-// 		return
-// 	}
-
-// 	if isFollowing {
-// 		// 언팔로우 처리
-// 		err = unfollow(ctx, followerId, followingId)
-// 	} else {
-// 		// 팔로우 처리
-// 		err = follow(ctx, followerId, followingId)
-// 	}
-
-// 	if err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-// 		return
-// 	}
-
-// 	c.JSON(http.StatusOK, gin.H{"status": "success"})
-// }
-
-// func isUserFollowing(ctx context.Context, followerId, followingId string) (bool, error) {
-// 	doc, err := dbClient.Collection("followings").Doc(followerId).Get(ctx)
-// 	if err != nil {
-// 		return false, err
-// 	}
-
-// 	data := doc.Data()
-// 	followingIds, ok := data["followingIds"].([]interface{})
-// 	if !ok {
-// 		return false, nil
-// 	}
-
-// 	for _, id := range followingIds {
-// 		if id == followingId {
-// 			return true, nil
-// 		}
-// 	}
-
-// 	return false, nil
-// }
-// func follow(ctx context.Context, followerId, followingId string) error {
-// 	// 팔로워 목록에 팔로우하는 사람 추가
-// 	_, err := dbClient.Collection("followers").Doc(followingId).Set(ctx, map[string]interface{}{
-// 		"followerIds": firestore.ArrayUnion(followerId),
-// 	}, firestore.MergeAll)
-
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// 팔로잉 목록에 팔로우 대상 추가
-// 	_, err = dbClient.Collection("followings").Doc(followerId).Set(ctx, map[string]interface{}{
-// 		"followingIds": firestore.ArrayUnion(followingId),
-// 	}, firestore.MergeAll)
-
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
-
-// func unfollow(ctx context.Context, followerId, followingId string) error {
-// 	// 팔로워 목록에서 팔로우하는 사람 제거
-// 	_, err := dbClient.Collection("followers").Doc(followingId).Set(ctx, map[string]interface{}{
-// 		"followerIds": firestore.ArrayRemove(followerId),
-// 	}, firestore.MergeAll)
-
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// 팔로잉 목록에서 팔로우 대상 제거
-// 	_, err = dbClient.Collection("followings").Doc(followerId).Set(ctx, map[string]interface{}{
-// 		"followingIds": firestore.ArrayRemove(followingId),
-// 	}, firestore.MergeAll)
-
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
+// hasBlocked reports whether userId blocked blockedId.
+func hasBlocked(userId string, blockedId string) (bool, error) {
+	docs, err := dbClient.Collection("blocklist").
+		Where("userId", "==", userId).
+		Where("blockedId", "==", blockedId).
+		Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	return len(docs) > 0, nil
+}
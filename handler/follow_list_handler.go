@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var followPageLimits = pagination.DefaultLimits
+
+// FollowEntry is one user in a follower or following list. ViewerFollows
+// tells whether the viewer follows that user.
+type FollowEntry struct {
+	UserInfo      UserInfo `json:"user_info"`
+	FollowedAt    string   `json:"followed_at"`
+	ViewerFollows bool     `json:"viewer_follows"`
+}
+
+// FollowPage is a page of a follower or following list, with the length of
+// the whole list.
+type FollowPage struct {
+	pagination.Page[FollowEntry]
+	Total int64 `json:"total"`
+}
+
+// GetFollowers lists the users following the user, most recent first.
+func GetFollowers(c *gin.Context) {
+	getFollowList(c, "followers", "following_id", "follower_id", func(stats UserStats) int64 { return stats.FollowerCount })
+}
+
+// GetFollowing lists the users the user follows, most recent first.
+func GetFollowing(c *gin.Context) {
+	getFollowList(c, "following", "follower_id", "following_id", func(stats UserStats) int64 { return stats.FollowingCount })
+}
+
+// getFollowList pages through the follows records whose ownField is the user,
+// listing the users in otherField.
+func getFollowList(c *gin.Context, list string, ownField string, otherField string, total func(UserStats) int64) {
+	userId := c.Param("id")
+	viewerId := c.DefaultQuery("viewer_id", "")
+
+	scope := list + ":" + userId
+	req, ok := parsePageRequest(c, scope, followPageLimits)
+	if !ok {
+		return
+	}
+
+	docs, nextCursor, err := getFollowDocs(ownField, userId, scope, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch users")
+		return
+	}
+
+	otherIds := make([]string, 0, len(docs))
+	followedAt := make([]time.Time, 0, len(docs))
+	for _, doc := range docs {
+		otherIds = append(otherIds, doc.Data()[otherField].(string))
+		followedAt = append(followedAt, doc.Data()["created_at"].(time.Time))
+	}
+
+	// Legacy follows without a record are not counted in user_stats and
+	// close the list.
+	legacy, err := legacyOnlyFollowIds(userId, list == "following")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if nextCursor == "" {
+		legacyIds := make([]string, 0, len(legacy))
+		for otherId := range legacy {
+			legacyIds = append(legacyIds, otherId)
+		}
+		sort.Slice(legacyIds, func(i, j int) bool {
+			if !legacy[legacyIds[i]].Equal(legacy[legacyIds[j]]) {
+				return legacy[legacyIds[i]].After(legacy[legacyIds[j]])
+			}
+			return legacyIds[i] < legacyIds[j]
+		})
+		for _, otherId := range legacyIds {
+			otherIds = append(otherIds, otherId)
+			followedAt = append(followedAt, legacy[otherId])
+		}
+	}
+
+	entries, err := followEntries(otherIds, followedAt, viewerId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := getUserStats([]string{userId})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, FollowPage{
+		Page:  pagination.NewPage(entries, nextCursor),
+		Total: total(stats[userId]) + int64(len(legacy)),
+	})
+}
+
+// legacyOnlyFollowIds is legacyFollowIds without the follows that already
+// have a record.
+func legacyOnlyFollowIds(userId string, following bool) (map[string]time.Time, error) {
+	legacy, err := legacyFollowIds(userId, following)
+	if err != nil || len(legacy) == 0 {
+		return legacy, err
+	}
+
+	otherIds := make([]string, 0, len(legacy))
+	refs := make([]*firestore.DocumentRef, 0, len(legacy))
+	for otherId := range legacy {
+		otherIds = append(otherIds, otherId)
+		if following {
+			refs = append(refs, followRef(userId, otherId))
+		} else {
+			refs = append(refs, followRef(otherId, userId))
+		}
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		if doc.Exists() {
+			delete(legacy, otherIds[i])
+		}
+	}
+	return legacy, nil
+}
+
+// getFollowDocs reads a page of the follows records whose field is userId,
+// most recent first, and the cursor of the next page.
+func getFollowDocs(field string, userId string, scope string, req pagination.Request) ([]*firestore.DocumentSnapshot, string, error) {
+	query := dbClient.Collection("follows").Where(field, "==", userId).OrderBy("created_at", firestore.Desc)
+	return pageQuery(query, "follows", scope, req)
+}
+
+// followEntries builds the list entries for the users followed or following
+// at the given times, reading the users and the viewer's follows records in
+// batches. Users that no longer exist are left out.
+func followEntries(otherIds []string, followedAt []time.Time, viewerId string) ([]FollowEntry, error) {
+	entries := []FollowEntry{}
+	if len(otherIds) == 0 {
+		return entries, nil
+	}
+
+	viewerRefs := make([]*firestore.DocumentRef, 0, len(otherIds))
+	for _, otherId := range otherIds {
+		viewerRefs = append(viewerRefs, followRef(viewerId, otherId))
+	}
+
+	userInfos, err := getUserInfos(otherIds)
+	if err != nil {
+		return nil, err
+	}
+
+	viewerFollows := make([]bool, len(otherIds))
+	if viewerId != "" {
+		viewerDocs, err := dbClient.GetAll(ctx, viewerRefs)
+		if err != nil {
+			return nil, err
+		}
+		legacy, err := legacyFollowIds(viewerId, true)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range viewerDocs {
+			_, legacyFollows := legacy[otherIds[i]]
+			viewerFollows[i] = doc.Exists() || legacyFollows
+		}
+	}
+
+	for i, otherId := range otherIds {
+		userInfo, ok := userInfos[otherId]
+		if !ok {
+			continue
+		}
+		entries = append(entries, FollowEntry{
+			UserInfo:      userInfo,
+			FollowedAt:    followedAt[i].Format(time.RFC3339),
+			ViewerFollows: viewerFollows[i],
+		})
+	}
+	return entries, nil
+}
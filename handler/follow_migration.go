@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Before follows records, follows were kept in two array documents:
+// followings/{followerId}.followingIds and followers/{followingId}.followerIds.
+// Until the follow_edges migration completed, reads honour edges still in
+// them, and unfollowing removes the edge from them. Legacy edges are not in
+// the user_stats counters; the migration counts them as it moves them.
+
+const followEdgesMigration = "follow_edges"
+
+// legacyFollowEdge is where a legacy list may hold a follow: id in the array
+// field of the document ref.
+type legacyFollowEdge struct {
+	ref   *firestore.DocumentRef
+	field string
+	id    string
+}
+
+func legacyFollowEdges(followerId string, followingId string) []legacyFollowEdge {
+	return []legacyFollowEdge{
+		{dbClient.Collection("followings").Doc(followerId), "followingIds", followingId},
+		{dbClient.Collection("followers").Doc(followingId), "followerIds", followerId},
+	}
+}
+
+func legacyListIds(doc *firestore.DocumentSnapshot, field string) []string {
+	if !doc.Exists() {
+		return nil
+	}
+	values, _ := doc.Data()[field].([]interface{})
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func heldLegacyEdges(edges []legacyFollowEdge, docs []*firestore.DocumentSnapshot) []legacyFollowEdge {
+	var held []legacyFollowEdge
+	for i, doc := range docs {
+		for _, id := range legacyListIds(doc, edges[i].field) {
+			if id == edges[i].id {
+				held = append(held, edges[i])
+				break
+			}
+		}
+	}
+	return held
+}
+
+// legacyFollowInTx returns the legacy lists, read in tx, that still hold the
+// follow. It is empty once the migration completed.
+func legacyFollowInTx(tx *firestore.Transaction, followerId string, followingId string) ([]legacyFollowEdge, error) {
+	done, err := isMigrationDone(followEdgesMigration)
+	if err != nil || done {
+		return nil, err
+	}
+
+	edges := legacyFollowEdges(followerId, followingId)
+	docs, err := tx.GetAll([]*firestore.DocumentRef{edges[0].ref, edges[1].ref})
+	if err != nil {
+		return nil, err
+	}
+	return heldLegacyEdges(edges, docs), nil
+}
+
+// isLegacyFollowing is legacyFollowInTx outside a transaction.
+func isLegacyFollowing(followerId string, followingId string) (bool, error) {
+	done, err := isMigrationDone(followEdgesMigration)
+	if err != nil || done {
+		return false, err
+	}
+
+	edges := legacyFollowEdges(followerId, followingId)
+	docs, err := dbClient.GetAll(ctx, []*firestore.DocumentRef{edges[0].ref, edges[1].ref})
+	if err != nil {
+		return false, err
+	}
+	return len(heldLegacyEdges(edges, docs)) > 0, nil
+}
+
+// legacyFollowIds returns the users on the other side of userId's legacy
+// follows, with when the list holding each was last written: the users it
+// follows when following is set, its followers otherwise. It is empty once
+// the migration completed.
+func legacyFollowIds(userId string, following bool) (map[string]time.Time, error) {
+	ids := make(map[string]time.Time)
+	if userId == "" {
+		return ids, nil
+	}
+	done, err := isMigrationDone(followEdgesMigration)
+	if err != nil || done {
+		return ids, err
+	}
+
+	own, ownField, other, otherField := "followings", "followingIds", "followers", "followerIds"
+	if !following {
+		own, ownField, other, otherField = other, otherField, own, ownField
+	}
+
+	doc, err := dbClient.Collection(own).Doc(userId).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	for _, id := range legacyListIds(doc, ownField) {
+		ids[id] = doc.UpdateTime
+	}
+
+	docs, err := dbClient.Collection(other).Where(otherField, "array-contains", userId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if _, ok := ids[doc.Ref.ID]; !ok {
+			ids[doc.Ref.ID] = doc.UpdateTime
+		}
+	}
+	delete(ids, userId)
+	return ids, nil
+}
+
+// migrateFollowLists moves follows kept in the legacy followings/followers
+// array documents into follows records and user_stats counters, deleting each
+// legacy document once its edges are moved. Edges that already have a record
+// are skipped, so the migration can be interrupted and run again.
+func migrateFollowLists() error {
+	legacy := []struct {
+		collection string
+		field      string
+		edge       func(docId string, id string) (string, string)
+	}{
+		{"followings", "followingIds", func(docId string, id string) (string, string) { return docId, id }},
+		{"followers", "followerIds", func(docId string, id string) (string, string) { return id, docId }},
+	}
+
+	failed := 0
+	for _, source := range legacy {
+		docs, err := dbClient.Collection(source.collection).Documents(ctx).GetAll()
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			ids, _ := doc.Data()[source.field].([]interface{})
+			migrated := true
+			for _, id := range ids {
+				followingId, ok := id.(string)
+				if !ok {
+					continue
+				}
+				followerId, followingId := source.edge(doc.Ref.ID, followingId)
+				if err := migrateFollowEdge(followerId, followingId); err != nil {
+					log.Printf("failed to migrate follow %s -> %s: %v", followerId, followingId, err)
+					migrated = false
+				}
+			}
+
+			if !migrated {
+				failed++
+				continue
+			}
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				log.Printf("failed to delete %s/%s: %v", source.collection, doc.Ref.ID, err)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d follow list documents were not migrated", failed)
+	}
+	return nil
+}
+
+func migrateFollowEdge(followerId string, followingId string) error {
+	if followerId == followingId {
+		return nil
+	}
+
+	edgeRef := followRef(followerId, followingId)
+	return dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(edgeRef)
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if err := tx.Create(edgeRef, map[string]interface{}{
+			"follower_id":  followerId,
+			"following_id": followingId,
+			"created_at":   time.Now(),
+		}); err != nil {
+			return err
+		}
+		if err := incrementUserStat(tx, followerId, "following_count", 1); err != nil {
+			return err
+		}
+		return incrementUserStat(tx, followingId, "follower_count", 1)
+	})
+}
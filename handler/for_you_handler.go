@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/gobloc/pagination"
+	"example.com/gobloc/ranking"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// forYouCandidateCount is how many of the most recent videos are ranked
+	// for the For You feed.
+	forYouCandidateCount = 200
+	forYouPageLimits     = pagination.Limits{Default: 10, Max: 30}
+
+	// forYouRankingTTL is how long the ranking behind a For You cursor is
+	// kept; older cursors are rejected.
+	forYouRankingTTL = time.Hour
+
+	// forYouExperiment splits users between the rankers of the For You feed.
+	forYouExperiment = ranking.Experiment{
+		Name: "foryou",
+		Arms: []ranking.Arm{
+			{Ranker: ranking.Balanced(), Weight: 50},
+			{Ranker: ranking.Engagement(), Weight: 50},
+		},
+	}
+)
+
+// ForYouPage is a page of the For You feed with the name of the ranker that
+// ordered it.
+type ForYouPage struct {
+	pagination.Page[Video]
+	Ranker string `json:"ranker"`
+}
+
+// GetForYouFeed lists recent videos ranked for the user by the ranker of
+// their experiment arm. Pages are offsets into the ranking, so the cursor is
+// bound to the ranker and carries the time the first page was ranked at. The
+// first page stores the whole ranking and later pages are served from it, so
+// new uploads and changing likes, chats and watches do not shift the offsets.
+func GetForYouFeed(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+	ranker := forYouExperiment.Assign(userId)
+
+	scope := "feed_foryou:" + userId + ":" + ranker.Name
+	req, ok := parsePageRequest(c, scope, forYouPageLimits)
+	if !ok {
+		return
+	}
+
+	blockedIds, err := getBlockedIds(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feed"})
+		return
+	}
+
+	asOf := req.AsOf
+	var ids []string
+	if asOf.IsZero() {
+		asOf = time.Now()
+		ids, err = rankForYou(userId, ranker, blockedIds, asOf)
+	} else {
+		ids, err = getForYouRanking(forYouRankingRef(userId, ranker.Name, asOf))
+	}
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch feed")
+		return
+	}
+
+	start := req.Offset
+	if start > len(ids) {
+		start = len(ids)
+	}
+	end := start + req.Size
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	if req.AsOf.IsZero() && end < len(ids) {
+		_, err := forYouRankingRef(userId, ranker.Name, asOf).Set(ctx, map[string]interface{}{
+			"video_ids":  ids,
+			"expires_at": asOf.Add(forYouRankingTTL),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feed"})
+			return
+		}
+	}
+
+	found, err := loadVideosByIds(ids[start:end])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feed"})
+		return
+	}
+	// Blocks made since the ranking was stored still apply.
+	videos := []Video{}
+	for _, id := range ids[start:end] {
+		video, ok := found[id]
+		if !ok || blockedIds[id] || blockedIds[video.Uploader] {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	if err := attachVideoStats(videos, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video stats"})
+		return
+	}
+
+	nextCursor := ""
+	if end < len(ids) {
+		nextCursor = pagination.AtOffsetAsOf(scope, end, asOf)
+	}
+	c.JSON(http.StatusOK, ForYouPage{
+		Page:   pagination.NewPage(videos, nextCursor),
+		Ranker: ranker.Name,
+	})
+}
+
+// forYouRankingRef returns the stored ranking of the user's For You feed
+// computed at asOf. Stored rankings are deleted by a TTL policy on
+// expires_at:
+//
+//	gcloud firestore fields ttls update expires_at --collection-group=foryou_rankings --enable-ttl
+func forYouRankingRef(userId string, rankerName string, asOf time.Time) *firestore.DocumentRef {
+	id := fmt.Sprintf("%s_%s_%d", userId, rankerName, asOf.UnixNano())
+	return dbClient.Collection("foryou_rankings").Doc(id)
+}
+
+// getForYouRanking reads the ranked video ids of a stored ranking. A ranking
+// that expired, even if the TTL policy has not deleted it yet, makes the
+// cursor invalid.
+func getForYouRanking(ref *firestore.DocumentRef) ([]string, error) {
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, pagination.ErrInvalidCursor
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt, _ := doc.Data()["expires_at"].(time.Time); time.Now().After(expiresAt) {
+		return nil, pagination.ErrInvalidCursor
+	}
+	return stringsFromFirestore(doc.Data()["video_ids"]), nil
+}
+
+// rankForYou ranks the candidates of the user's For You feed at now and
+// returns the ranked video ids.
+func rankForYou(userId string, ranker ranking.Ranker, blockedIds map[string]bool, now time.Time) ([]string, error) {
+	candidates, err := getForYouCandidates(userId, blockedIds, now)
+	if err != nil {
+		return nil, err
+	}
+	ranked := ranker.Rank(ranking.Context{ViewerId: userId, Now: now}, candidates)
+	ids := make([]string, 0, len(ranked))
+	for _, entry := range ranked {
+		ids = append(ids, entry.Candidate.VideoId)
+	}
+	return ids, nil
+}
+
+// getForYouCandidates reads the most recent videos uploaded up to asOf,
+// leaving out blocked ones, with their ranking signals. Like counts are the
+// compacted like_count, which trails the live count by at most one
+// compaction interval.
+func getForYouCandidates(userId string, blockedIds map[string]bool, asOf time.Time) ([]ranking.Candidate, error) {
+	docs, err := dbClient.Collection("videos").Where("upload_time", "<=", asOf).OrderBy("upload_time", firestore.Desc).Limit(forYouCandidateCount).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	videoIds := []string{}
+	followRefs := []*firestore.DocumentRef{}
+	candidates := []ranking.Candidate{}
+	for _, doc := range docs {
+		uploader := doc.Data()["uploader"].(string)
+		if blockedIds[doc.Ref.ID] || blockedIds[uploader] {
+			continue
+		}
+		likeCount, _ := doc.Data()["like_count"].(int64)
+		chatCount, _ := doc.Data()["chat_count"].(int64)
+		candidates = append(candidates, ranking.Candidate{
+			VideoId:    doc.Ref.ID,
+			UploaderId: uploader,
+			UploadTime: doc.Data()["upload_time"].(time.Time),
+			LikeCount:  int(likeCount),
+			ChatCount:  int(chatCount),
+		})
+		videoIds = append(videoIds, doc.Ref.ID)
+		followRefs = append(followRefs, followRef(userId, uploader))
+	}
+
+	watch, err := getWatchStats(videoIds)
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		if stats, ok := watch[candidates[i].VideoId]; ok && stats.ViewCount > 0 {
+			candidates[i].WatchCount = stats.ViewCount
+			candidates[i].WatchCompletion = stats.CompletionSum / float64(stats.ViewCount)
+		}
+	}
+
+	if userId != "" && len(followRefs) > 0 {
+		follows, err := dbClient.GetAll(ctx, followRefs)
+		if err != nil {
+			return nil, err
+		}
+		legacy, err := legacyFollowIds(userId, true)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range follows {
+			_, legacyFollows := legacy[candidates[i].UploaderId]
+			candidates[i].ViewerFollowsUploader = doc.Exists() || legacyFollows
+		}
+	}
+
+	return candidates, nil
+}
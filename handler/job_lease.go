@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Background jobs that must run on one instance at a time hold a lease in
+// jobs/{name}. The instance holding it renews it on every run; another
+// instance takes it over once it expires.
+
+// instanceId identifies this process as a lease owner.
+var instanceId = newInstanceId()
+
+func newInstanceId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func jobLeaseRef(name string) *firestore.DocumentRef {
+	return dbClient.Collection("jobs").Doc(name)
+}
+
+// acquireJobLease takes or renews the lease of the named job for ttl and
+// reports whether this instance holds it.
+func acquireJobLease(name string, ttl time.Duration) (bool, error) {
+	ref := jobLeaseRef(name)
+	held := false
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		held = false
+
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			owner, _ := doc.Data()["owner"].(string)
+			expiresAt, _ := doc.Data()["expires_at"].(time.Time)
+			if owner != instanceId && time.Now().Before(expiresAt) {
+				return nil
+			}
+		}
+
+		held = true
+		return tx.Set(ref, map[string]interface{}{
+			"owner":      instanceId,
+			"expires_at": time.Now().Add(ttl),
+		})
+	})
+	return held, err
+}
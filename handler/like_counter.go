@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Like counts are distributed over likeShardCount shard documents under each
+// video so that bursts of likes on one video do not contend on a single
+// document. The total is the video's like_count plus the sum of its shards;
+// the compaction job periodically folds the shards back into like_count, which
+// keeps like_count close to the total for queries that order by it.
+var (
+	likeShardCount         = 10
+	likeCountCacheTTL      = 5 * time.Second
+	likeCompactionInterval = time.Minute
+)
+
+type cachedLikeCount struct {
+	count   int
+	expires time.Time
+}
+
+var (
+	likeCountCache     = make(map[string]cachedLikeCount)
+	likeCountCacheLock = sync.Mutex{}
+)
+
+func likeShardsRef(videoId string) *firestore.CollectionRef {
+	return dbClient.Collection("videos").Doc(videoId).Collection("like_shards")
+}
+
+// randomLikeShard picks the shard a like or unlike is written to.
+func randomLikeShard(videoId string) *firestore.DocumentRef {
+	return likeShardsRef(videoId).Doc(strconv.Itoa(rand.Intn(likeShardCount)))
+}
+
+// incrementLikeShard adds delta to a random shard of the video's counter as
+// part of tx.
+func incrementLikeShard(tx *firestore.Transaction, videoId string, delta int64) error {
+	return tx.Set(randomLikeShard(videoId), map[string]interface{}{
+		"count": firestore.Increment(delta),
+	}, firestore.MergeAll)
+}
+
+// getLikeCount returns the video's total like count, served from a short
+// lived in-process cache.
+func getLikeCount(videoId string) (int, error) {
+	likeCountCacheLock.Lock()
+	cached, ok := likeCountCache[videoId]
+	likeCountCacheLock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.count, nil
+	}
+
+	counts, err := readLikeCounts([]string{videoId})
+	if err != nil {
+		return 0, err
+	}
+	count, ok := counts[videoId]
+	if !ok {
+		return 0, status.Errorf(codes.NotFound, "video %s not found", videoId)
+	}
+	return count, nil
+}
+
+// readLikeCounts reads the like counts of the videos and caches them. Each
+// video document is read with its shards in one batch, which Firestore reads
+// at a single point in time, so a compaction moving counts from the shards
+// into like_count is never seen half done. Videos that do not exist are left
+// out.
+func readLikeCounts(videoIds []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(videoIds) == 0 {
+		return counts, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(videoIds)*(likeShardCount+1))
+	for _, videoId := range videoIds {
+		refs = append(refs, dbClient.Collection("videos").Doc(videoId))
+		for shard := 0; shard < likeShardCount; shard++ {
+			refs = append(refs, likeShardsRef(videoId).Doc(strconv.Itoa(shard)))
+		}
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, videoId := range videoIds {
+		group := docs[i*(likeShardCount+1) : (i+1)*(likeShardCount+1)]
+		if !group[0].Exists() {
+			continue
+		}
+		count := likeCountFromDocs(group[0], group[1:])
+		cacheLikeCount(videoId, count)
+		counts[videoId] = count
+	}
+	return counts, nil
+}
+
+// likeCountFromDocs adds up like_count and the shards. Shards that do not
+// exist count as zero.
+func likeCountFromDocs(videoDoc *firestore.DocumentSnapshot, shards []*firestore.DocumentSnapshot) int {
+	total, _ := videoDoc.Data()["like_count"].(int64)
+	for _, shard := range shards {
+		if count, ok := shard.Data()["count"].(int64); ok {
+			total += count
+		}
+	}
+	return int(total)
+}
+
+func cacheLikeCount(videoId string, count int) {
+	likeCountCacheLock.Lock()
+	defer likeCountCacheLock.Unlock()
+
+	likeCountCache[videoId] = cachedLikeCount{count: count, expires: time.Now().Add(likeCountCacheTTL)}
+}
+
+// adjustLikeCount applies a like or unlike made by this process to the cached
+// count and returns the new total.
+func adjustLikeCount(videoId string, delta int) (int, error) {
+	likeCountCacheLock.Lock()
+	cached, ok := likeCountCache[videoId]
+	if ok && time.Now().Before(cached.expires) {
+		cached.count += delta
+		likeCountCache[videoId] = cached
+		likeCountCacheLock.Unlock()
+		return cached.count, nil
+	}
+	likeCountCacheLock.Unlock()
+
+	return getLikeCount(videoId)
+}
+
+// StartLikeCompaction runs the like counter compaction in the background, on
+// whichever instance holds the like compaction lease.
+func StartLikeCompaction() {
+	go func() {
+		ticker := time.NewTicker(likeCompactionInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			held, err := acquireJobLease("like_compaction", 2*likeCompactionInterval)
+			if err != nil {
+				log.Printf("failed to acquire like compaction lease: %v", err)
+				continue
+			}
+			if !held {
+				continue
+			}
+			if err := compactLikeShards(); err != nil {
+				log.Printf("like compaction failed: %v", err)
+			}
+		}
+	}()
+}
+
+// compactLikeShards folds every non-empty shard into its video's like_count.
+//
+// Single-field indexes only cover collection queries by default, so the
+// collection group query on count needs an index exemption on
+// like_shards.count enabling collection group scope:
+//
+//	gcloud firestore indexes fields update count --collection-group=like_shards --index=order=ascending,query-scope=collection-group
+func compactLikeShards() error {
+	docs, err := dbClient.CollectionGroup("like_shards").Where("count", "!=", 0).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	videoIds := make(map[string]bool)
+	for _, doc := range docs {
+		videoIds[doc.Ref.Parent.Parent.ID] = true
+	}
+
+	for videoId := range videoIds {
+		moved, err := compactVideoLikes(videoId)
+		if err != nil {
+			log.Printf("failed to compact likes of %s: %v", videoId, err)
+			continue
+		}
+		if moved != 0 {
+			if err := searchIndex.AddPopularity(searchKindVideo, videoId, float64(moved)); err != nil {
+				log.Printf("failed to update search popularity of %s: %v", videoId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compactVideoLikes moves the sum of the video's shards into like_count and
+// the uploader's total_likes, and resets the shards, returning the amount
+// moved.
+func compactVideoLikes(videoId string) (int64, error) {
+	videoRef := dbClient.Collection("videos").Doc(videoId)
+
+	var moved int64
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		moved = 0
+
+		shards, err := tx.Documents(likeShardsRef(videoId)).GetAll()
+		if err != nil {
+			return err
+		}
+		videoDoc, err := tx.Get(videoRef)
+		if status.Code(err) == codes.NotFound {
+			// The video was deleted; its counter goes with it. Its likes were
+			// taken off the uploader's total when it was deleted.
+			for _, shard := range shards {
+				if err := tx.Delete(shard.Ref); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, shard := range shards {
+			count, _ := shard.Data()["count"].(int64)
+			if count == 0 {
+				continue
+			}
+			moved += count
+			if err := tx.Update(shard.Ref, []firestore.Update{{Path: "count", Value: 0}}); err != nil {
+				return err
+			}
+		}
+		if moved == 0 {
+			return nil
+		}
+
+		likeCount, _ := videoDoc.Data()["like_count"].(int64)
+		if err := tx.Update(videoRef, []firestore.Update{
+			{Path: "like_count", Value: likeCount + moved},
+		}); err != nil {
+			return err
+		}
+		return incrementUserStat(tx, videoDoc.Data()["uploader"].(string), "total_likes", moved)
+	})
+	return moved, err
+}
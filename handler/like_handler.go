@@ -1,9 +1,11 @@
 package handler
 
 import (
-	"context"
 	"net/http"
 	"strconv"
+	"time"
+
+	"example.com/gobloc/pagination"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
@@ -11,8 +13,10 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+var likesPageLimits = pagination.DefaultLimits
+
 func UpdateLikes(c *gin.Context) {
-	userID := c.Param("userID")
+	userID := c.Param("id")
 	videoID := c.Param("videoID")
 	action, err := strconv.Atoi(c.PostForm("action"))
 
@@ -21,53 +25,169 @@ func UpdateLikes(c *gin.Context) {
 		return
 	}
 
-	// user_likes 컬렉션 참조
-	userLikesRef := dbClient.Collection("user_likes").Doc(userID)
-	videoRef := dbClient.Collection("videos").Doc(videoID)
+	respondLike(c, userID, videoID, action == 1)
+}
 
-	err = dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		// user_likes 도큐먼트 가져오기
-		userLikesDoc, err := tx.Get(userLikesRef)
-		if err != nil && status.Code(err) != codes.NotFound {
-			return err
-		}
+// LikeVideo and UnlikeVideo set the like state directly, so repeating either
+// request is harmless.
+func LikeVideo(c *gin.Context) {
+	respondLike(c, c.Param("id"), c.Param("videoID"), true)
+}
 
-		var userLikes map[string]bool
-		if userLikesDoc.Exists() {
-			if err := userLikesDoc.DataTo(&userLikes); err != nil {
-				return err
-			}
-		} else {
-			userLikes = make(map[string]bool)
-		}
+func UnlikeVideo(c *gin.Context) {
+	respondLike(c, c.Param("id"), c.Param("videoID"), false)
+}
 
-		if action == 1 {
-			userLikes[videoID] = true
-		} else {
-			delete(userLikes, videoID)
-		}
+func respondLike(c *gin.Context, userID string, videoID string, liked bool) {
+	state, err := setLike(userID, videoID, liked)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update likes"})
+		return
+	}
 
-		tx.Set(userLikesRef, userLikes)
+	broadcastToRoom(videoID, Event{
+		EventType: "total_like",
+		TotalLike: &state.LikeCount,
+		UserLike:  &state.Liked,
+		UserId:    &userID,
+	})
 
-		// 좋아요 수 업데이트
-		updateData := []firestore.Update{
-			{
-				Path:  "LikeCount",
-				Value: firestore.Increment(action),
-			},
+	c.JSON(http.StatusOK, state)
+}
+
+// GetLikedVideos lists the videos the user liked, most recent like first.
+// Videos that were deleted, or that the viewer blocked directly or through
+// their uploader, are left out.
+func GetLikedVideos(c *gin.Context) {
+	userID := c.Param("id")
+	viewerID := c.DefaultQuery("viewer_id", userID)
+
+	scope := "likes:" + userID
+	req, ok := parsePageRequest(c, scope, likesPageLimits)
+	if !ok {
+		return
+	}
+
+	videos, lastLikeID, err := getLikedVideos(userID, viewerID, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch liked videos")
+		return
+	}
+
+	nextCursor := ""
+	if lastLikeID != "" {
+		nextCursor = pagination.After(scope, lastLikeID)
+	}
+	c.JSON(http.StatusOK, pagination.NewPage(videos, nextCursor))
+}
+
+// getLikedVideos walks the user's like records after the requested one until
+// a page of visible videos is found or the records run out. It returns the id
+// of the last like record of the page when more may follow.
+func getLikedVideos(userID string, viewerID string, req pagination.Request) ([]Video, string, error) {
+	blockedIds, err := getBlockedIds(viewerID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := dbClient.Collection("likes").Where("user_id", "==", userID).OrderBy("created_at", firestore.Desc)
+	cursor, err := cursorDoc("likes", req)
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor != nil {
+		query = query.StartAfter(cursor)
+	}
+
+	videos := []Video{}
+	for {
+		likeDocs, err := query.Limit(req.Size).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, "", err
+		}
+
+		videoIds := make([]string, 0, len(likeDocs))
+		for _, doc := range likeDocs {
+			videoIds = append(videoIds, doc.Data()["video_id"].(string))
 		}
-		err = tx.Update(videoRef, updateData)
+		found, err := loadVideosByIds(videoIds)
 		if err != nil {
-			return err
+			return nil, "", err
 		}
 
-		return nil
-	})
+		for i, doc := range likeDocs {
+			video, ok := found[videoIds[i]]
+			if !ok || blockedIds[video.Id] || blockedIds[video.Uploader] {
+				continue
+			}
+			videos = append(videos, video)
+			if len(videos) == req.Size {
+				if i == len(likeDocs)-1 && len(likeDocs) < req.Size {
+					return videos, "", attachVideoStats(videos, viewerID)
+				}
+				return videos, doc.Ref.ID, attachVideoStats(videos, viewerID)
+			}
+		}
+
+		if len(likeDocs) < req.Size {
+			return videos, "", attachVideoStats(videos, viewerID)
+		}
+		query = query.StartAfter(likeDocs[len(likeDocs)-1])
+	}
+}
+
+// loadVideosByIds reads videos and their uploaders in batches, keyed by video
+// id. Deleted videos and videos whose uploader is gone are left out.
+func loadVideosByIds(videoIds []string) (map[string]Video, error) {
+	videos := make(map[string]Video)
+	if len(videoIds) == 0 {
+		return videos, nil
+	}
 
+	videoRefs := make([]*firestore.DocumentRef, 0, len(videoIds))
+	for _, id := range videoIds {
+		videoRefs = append(videoRefs, dbClient.Collection("videos").Doc(id))
+	}
+	videoDocs, err := dbClient.GetAll(ctx, videoRefs)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update likes"})
-		return
+		return nil, err
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Likes updated successfully"})
+	uploaders := []string{}
+	for _, doc := range videoDocs {
+		if doc.Exists() {
+			uploaders = append(uploaders, doc.Data()["uploader"].(string))
+		}
+	}
+	userInfos, err := getUserInfos(uploaders)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range videoDocs {
+		if !doc.Exists() {
+			continue
+		}
+		userInfo, ok := userInfos[doc.Data()["uploader"].(string)]
+		if !ok {
+			continue
+		}
+		description, _ := doc.Data()["description"].(string)
+		videos[doc.Ref.ID] = Video{
+			Id:          doc.Ref.ID,
+			Title:       doc.Data()["title"].(string),
+			Description: description,
+			Tags:        stringsFromFirestore(doc.Data()["tags"]),
+			Uploader:    doc.Data()["uploader"].(string),
+			Url:         doc.Data()["url"].(string),
+			Upload_time: doc.Data()["upload_time"].(time.Time).Format(time.RFC3339),
+			Thumbnail:   doc.Data()["thumbnail"].(string),
+			UserInfo:    userInfo,
+		}
+	}
+	return videos, nil
 }
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Before per-user like records, the videos a user liked were kept in the
+// like_videos map of user_likes/{userId}. Those likes are already counted in
+// the videos' like_count, so moving them creates records without touching
+// the counters.
+
+const likeRecordsMigration = "like_records"
+
+func legacyLikesRef(userId string) *firestore.DocumentRef {
+	return dbClient.Collection("user_likes").Doc(userId)
+}
+
+func legacyLikedVideos(doc *firestore.DocumentSnapshot) []string {
+	likedVideos, _ := doc.Data()["like_videos"].(map[string]interface{})
+	var videoIds []string
+	for videoId, liked := range likedVideos {
+		if liked == true {
+			videoIds = append(videoIds, videoId)
+		}
+	}
+	return videoIds
+}
+
+// migrateLegacyLikes moves the likes of the user_likes documents into like
+// records, deleting each document once its likes are moved. Likes that
+// already have a record are skipped, so the migration can be interrupted and
+// run again.
+func migrateLegacyLikes() error {
+	docs, err := dbClient.Collection("user_likes").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, doc := range docs {
+		migrated := true
+		for _, videoId := range legacyLikedVideos(doc) {
+			if err := migrateLegacyLike(doc.Ref.ID, videoId); err != nil {
+				log.Printf("failed to migrate like of %s on %s: %v", doc.Ref.ID, videoId, err)
+				migrated = false
+			}
+		}
+
+		if !migrated {
+			failed++
+			continue
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			log.Printf("failed to delete user_likes/%s: %v", doc.Ref.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d user_likes documents were not migrated", failed)
+	}
+	return nil
+}
+
+// migrateLegacyLike creates the like record of a like in user_likes. The
+// user_likes document is read again in the transaction, so a like removed
+// since the migration listed it is not brought back.
+func migrateLegacyLike(userId string, videoId string) error {
+	userLikeRef := likeRef(userId, videoId)
+	videoRef := dbClient.Collection("videos").Doc(videoId)
+	return dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(userLikeRef)
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		legacy, err := tx.Get(legacyLikesRef(userId))
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			return err
+		}
+		likedVideos, _ := legacy.Data()["like_videos"].(map[string]interface{})
+		if likedVideos[videoId] != true {
+			return nil
+		}
+
+		// Likes of deleted videos are dropped.
+		if _, err := tx.Get(videoRef); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			return err
+		}
+
+		return tx.Create(userLikeRef, map[string]interface{}{
+			"user_id":    userId,
+			"video_id":   videoId,
+			"created_at": legacy.UpdateTime,
+		})
+	})
+}
+
+// legacyLikeInTx reports whether the user's user_likes document, read in tx,
+// still holds a like of the video. It is false once the migration completed.
+func legacyLikeInTx(tx *firestore.Transaction, userId string, videoId string) (bool, error) {
+	done, err := isMigrationDone(likeRecordsMigration)
+	if err != nil || done {
+		return false, err
+	}
+
+	doc, err := tx.Get(legacyLikesRef(userId))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	likedVideos, _ := doc.Data()["like_videos"].(map[string]interface{})
+	return likedVideos[videoId] == true, nil
+}
+
+// isLegacyLiked is legacyLikeInTx outside a transaction.
+func isLegacyLiked(userId string, videoId string) (bool, error) {
+	done, err := isMigrationDone(likeRecordsMigration)
+	if err != nil || done {
+		return false, err
+	}
+
+	doc, err := legacyLikesRef(userId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	likedVideos, _ := doc.Data()["like_videos"].(map[string]interface{})
+	return likedVideos[videoId] == true, nil
+}
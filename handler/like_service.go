@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Likes are stored as one record per (user, video) in the likes collection,
+// and counted in the video's sharded like counter (see like_counter.go). Both
+// are always written in the same transaction so the counter matches the
+// records. Until the like_records migration completes, likes still in the
+// legacy user_likes documents count as well (see like_migration.go).
+
+type LikeState struct {
+	VideoId   string `json:"video_id"`
+	Liked     bool   `json:"liked"`
+	LikeCount int    `json:"like_count"`
+}
+
+func likeRef(userId string, videoId string) *firestore.DocumentRef {
+	return dbClient.Collection("likes").Doc(userId + "_" + videoId)
+}
+
+// isVideoLiked reports whether the user has a like record for the video.
+func isVideoLiked(userId string, videoId string) (bool, error) {
+	if userId == "" {
+		return false, nil
+	}
+
+	_, err := likeRef(userId, videoId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return isLegacyLiked(userId, videoId)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// setLike makes the user like or unlike the video. Setting the state the
+// user is already in changes nothing, so retries are safe.
+func setLike(userId string, videoId string, liked bool) (LikeState, error) {
+	return updateLike(userId, videoId, func(bool) bool { return liked })
+}
+
+// toggleLike flips the user's like on the video.
+func toggleLike(userId string, videoId string) (LikeState, error) {
+	return updateLike(userId, videoId, func(current bool) bool { return !current })
+}
+
+// updateLike reads the user's like record in a transaction, and writes the
+// record and a counter shard when the desired state differs. The video
+// document itself is not touched, so likes on one video do not contend.
+func updateLike(userId string, videoId string, desired func(current bool) bool) (LikeState, error) {
+	userLikeRef := likeRef(userId, videoId)
+
+	if _, err := dbClient.Collection("videos").Doc(videoId).Get(ctx); err != nil {
+		return LikeState{}, err
+	}
+
+	var state LikeState
+	var delta int64
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		delta = 0
+
+		likeDoc, err := tx.Get(userLikeRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		legacyLiked := false
+		if !likeDoc.Exists() {
+			if legacyLiked, err = legacyLikeInTx(tx, userId, videoId); err != nil {
+				return err
+			}
+		}
+
+		current := likeDoc.Exists() || legacyLiked
+		state = LikeState{VideoId: videoId, Liked: desired(current)}
+
+		if state.Liked == current {
+			return nil
+		}
+
+		delta = 1
+		if state.Liked {
+			now := time.Now()
+			if err := recordTrendingActivity(tx, videoId, now, "likes", 1); err != nil {
+				return err
+			}
+			err = tx.Create(userLikeRef, map[string]interface{}{
+				"user_id":    userId,
+				"video_id":   videoId,
+				"created_at": now,
+			})
+		} else if legacyLiked {
+			delta = -1
+			err = tx.Update(legacyLikesRef(userId), []firestore.Update{
+				{FieldPath: firestore.FieldPath{"like_videos", videoId}, Value: firestore.Delete},
+			})
+		} else {
+			delta = -1
+			// The like no longer counts towards trending in the bucket it
+			// was counted in.
+			createdAt, _ := likeDoc.Data()["created_at"].(time.Time)
+			if err := recordTrendingActivity(tx, videoId, createdAt, "likes", -1); err != nil {
+				return err
+			}
+			err = tx.Delete(userLikeRef)
+		}
+		if err != nil {
+			return err
+		}
+
+		return incrementLikeShard(tx, videoId, delta)
+	})
+	if err != nil {
+		return LikeState{}, err
+	}
+
+	state.LikeCount, err = adjustLikeCount(videoId, int(delta))
+	if err != nil {
+		return LikeState{}, err
+	}
+	return state, nil
+}
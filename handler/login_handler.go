@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
 	"cloud.google.com/go/firestore"
@@ -12,24 +13,23 @@ import (
 func LoginHandler(c *gin.Context) {
 	// 클라이언트에서 전송한 사용자 ID를 가져옵니다.
 	userID := c.PostForm("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID is required"})
+		return
+	}
 
 	// Firestore에서 사용자 ID를 확인합니다.
 	user, err := dbClient.Collection("users").Doc(userID).Get(ctx)
 	if err != nil {
 		// 사용자 ID가 없으면 새 사용자를 추가합니다.
-		nickname := "user" + userID[:5]
-		_, err = dbClient.Collection("users").Doc(userID).Set(ctx, map[string]interface{}{
-			"id":           userID,
-			"image":        "",
-			"thumbnail":    "",
-			"nickname":     nickname,
-			"introduction": "hello",
-		})
-
+		nickname, err := createUser(userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
 			return
 		}
+		if err := searchIndex.Index(userSearchDocument(userID, nickname, "hello", 0)); err != nil {
+			log.Printf("failed to index user %s: %v", userID, err)
+		}
 
 		c.JSON(http.StatusOK, UserInfo{
 			Id:        userID,
@@ -63,6 +63,7 @@ func RemoveHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	invalidateUserInfo(userID)
 	defer iter.Stop()
 
 	deleteCount := 0
@@ -79,7 +80,7 @@ func RemoveHandler(c *gin.Context) {
 			return
 		}
 
-		_, err = doc.Ref.Delete(ctx)
+		err = deleteVideoDoc(doc.Ref)
 		if err != nil {
 			println("1111")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+// Mention is a resolved @nickname inside a message text. Offset and Length
+// are counted in characters (runes) and cover the leading '@'.
+type Mention struct {
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+type Notification struct {
+	Id           string `json:"id"`
+	UserId       string `json:"user_id"`
+	Type         string `json:"type"`
+	RoomId       string `json:"room_id"`
+	FromUserId   string `json:"from_user_id"`
+	FromNickname string `json:"from_nickname"`
+	Text         string `json:"text"`
+	CreatedAt    string `json:"created_at"`
+	Read         bool   `json:"read"`
+}
+
+// mentionPattern matches an @nickname at the start of the text or after a
+// character that cannot be part of a nickname, so e-mail addresses are not
+// taken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.])@([\p{L}\p{N}_]+(?:\.[\p{L}\p{N}_]+)*)`)
+
+var notificationPageLimits = pagination.DefaultLimits
+
+// parseMentions returns the mentions found in text without user ids.
+func parseMentions(text string) []Mention {
+	var mentions []Mention
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// The match may start with the character before the '@'.
+		start := loc[2] - len("@")
+		mentions = append(mentions, Mention{
+			Nickname: text[loc[2]:loc[3]],
+			Offset:   utf8.RuneCountInString(text[:start]),
+			Length:   utf8.RuneCountInString(text[start:loc[3]]),
+		})
+	}
+	return mentions
+}
+
+// resolveMentions parses the mentions in text and looks up the users they
+// refer to. Mentions of unknown nicknames are dropped.
+func resolveMentions(text string) ([]Mention, error) {
+	mentions := parseMentions(text)
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	nicknames := make([]string, 0, len(mentions))
+	seen := make(map[string]bool)
+	for _, mention := range mentions {
+		if !seen[mention.Nickname] {
+			seen[mention.Nickname] = true
+			nicknames = append(nicknames, mention.Nickname)
+		}
+	}
+
+	userIds := make(map[string]string)
+	// Firestore limits "in" filters to 10 values.
+	for start := 0; start < len(nicknames); start += 10 {
+		end := start + 10
+		if end > len(nicknames) {
+			end = len(nicknames)
+		}
+
+		docs, err := dbClient.Collection("users").Where("nickname", "in", nicknames[start:end]).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			nickname, _ := doc.Data()["nickname"].(string)
+			if _, ok := userIds[nickname]; !ok {
+				userIds[nickname] = doc.Ref.ID
+			}
+		}
+	}
+
+	resolved := make([]Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		if userId, ok := userIds[mention.Nickname]; ok {
+			mention.UserId = userId
+			resolved = append(resolved, mention)
+		}
+	}
+	return resolved, nil
+}
+
+func mentionsToFirestore(mentions []Mention) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(mentions))
+	for _, mention := range mentions {
+		data = append(data, map[string]interface{}{
+			"userId":   mention.UserId,
+			"nickname": mention.Nickname,
+			"offset":   mention.Offset,
+			"length":   mention.Length,
+		})
+	}
+	return data
+}
+
+func mentionsFromFirestore(value interface{}) []Mention {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	mentions := make([]Mention, 0, len(items))
+	for _, item := range items {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		offset, _ := data["offset"].(int64)
+		length, _ := data["length"].(int64)
+		mention := Mention{
+			Offset: int(offset),
+			Length: int(length),
+		}
+		mention.UserId, _ = data["userId"].(string)
+		mention.Nickname, _ = data["nickname"].(string)
+		mentions = append(mentions, mention)
+	}
+	return mentions
+}
+
+// notifyMentions stores a notification for every user mentioned in msg and
+// pushes it to the connections they have open in other rooms. Users connected
+// to roomId already receive the message itself.
+func notifyMentions(msg Message, roomId string) error {
+	notified := make(map[string]bool)
+	for _, mention := range msg.Mentions {
+		if mention.UserId == msg.UserId || notified[mention.UserId] {
+			continue
+		}
+		notified[mention.UserId] = true
+
+		createdAt := time.Now()
+		notificationRef, _, err := dbClient.Collection("notifications").Add(ctx, map[string]interface{}{
+			"userId":       mention.UserId,
+			"type":         "mention",
+			"roomId":       roomId,
+			"fromUserId":   msg.UserId,
+			"fromNickname": msg.Nickname,
+			"text":         msg.Text,
+			"createdAt":    createdAt,
+			"read":         false,
+		})
+		if err != nil {
+			return err
+		}
+
+		notification := Notification{
+			Id:           notificationRef.ID,
+			UserId:       mention.UserId,
+			Type:         "mention",
+			RoomId:       roomId,
+			FromUserId:   msg.UserId,
+			FromNickname: msg.Nickname,
+			Text:         msg.Text,
+			CreatedAt:    createdAt.Format(time.RFC3339),
+		}
+		sendToUser(mention.UserId, roomId, Event{
+			EventType:    "notification",
+			Notification: &notification,
+		})
+	}
+	return nil
+}
+
+// sendToUser delivers event to every room, except skipRoomId, in which the
+// user currently has a connection.
+func sendToUser(userId string, skipRoomId string, event Event) {
+	event.recipient = userId
+
+	var targets []*Room
+	lock.RLock()
+	for roomId, room := range rooms {
+		if roomId == skipRoomId {
+			continue
+		}
+		for user := range room.Users {
+			if user.UserId == userId {
+				targets = append(targets, room)
+				break
+			}
+		}
+	}
+	lock.RUnlock()
+
+	for _, room := range targets {
+		room.broadcast(event)
+	}
+}
+
+func GetNotifications(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is missing"})
+		return
+	}
+
+	scope := "notifications:" + userId
+	req, ok := parsePageRequest(c, scope, notificationPageLimits)
+	if !ok {
+		return
+	}
+
+	query := dbClient.Collection("notifications").Where("userId", "==", userId).OrderBy("createdAt", firestore.Desc)
+	docs, nextCursor, err := pageQuery(query, "notifications", scope, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch notifications")
+		return
+	}
+
+	notifications := make([]Notification, 0, len(docs))
+	for _, doc := range docs {
+		notifications = append(notifications, Notification{
+			Id:           doc.Ref.ID,
+			UserId:       doc.Data()["userId"].(string),
+			Type:         doc.Data()["type"].(string),
+			RoomId:       doc.Data()["roomId"].(string),
+			FromUserId:   doc.Data()["fromUserId"].(string),
+			FromNickname: doc.Data()["fromNickname"].(string),
+			Text:         doc.Data()["text"].(string),
+			CreatedAt:    doc.Data()["createdAt"].(time.Time).Format(time.RFC3339),
+			Read:         doc.Data()["read"].(bool),
+		})
+	}
+
+	c.JSON(http.StatusOK, pagination.NewPage(notifications, nextCursor))
+}
+
+func MarkNotificationsRead(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is missing"})
+		return
+	}
+
+	docs, err := dbClient.Collection("notifications").Where("userId", "==", userId).Where("read", "==", false).Documents(ctx).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, doc := range docs {
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "read", Value: true}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Notifications marked as read"})
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Mention
+	}{
+		{"@alice hi", []Mention{{Nickname: "alice", Offset: 0, Length: 6}}},
+		{"hi @alice and @bob.", []Mention{{Nickname: "alice", Offset: 3, Length: 6}, {Nickname: "bob", Offset: 14, Length: 4}}},
+		{"(@alice)", []Mention{{Nickname: "alice", Offset: 1, Length: 6}}},
+		{"안녕 @철수", []Mention{{Nickname: "철수", Offset: 3, Length: 3}}},
+		{"@jane.doe hi", []Mention{{Nickname: "jane.doe", Offset: 0, Length: 9}}},
+		{"mail bob@example.com", nil},
+		{"a@b", nil},
+		{"@alice@bob", []Mention{{Nickname: "alice", Offset: 0, Length: 6}}},
+	}
+
+	for _, test := range tests {
+		if got := parseMentions(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseMentions(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
+
+// TestSendToUserWhileUsersDisconnect delivers mention notifications while the
+// room's broadcast loop drops SSE users whose buffers are full. Run with -race.
+func TestSendToUserWhileUsersDisconnect(t *testing.T) {
+	const users = 50
+	roomIds := []string{"race-room-1", "race-room-2"}
+
+	var wg sync.WaitGroup
+	for _, roomId := range roomIds {
+		var room *Room
+		for i := 0; i < users; i++ {
+			// Without buffer space every send fails and the user is dropped.
+			room = joinRoom(roomId, &User{RoomId: roomId, UserId: fmt.Sprintf("viewer-%d", i%5), Events: make(chan Event)})
+		}
+
+		wg.Add(1)
+		go func(room *Room) {
+			defer wg.Done()
+			for i := 0; i < users; i++ {
+				room.broadcast(Event{EventType: "message"})
+			}
+		}(room)
+	}
+
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sendToUser(fmt.Sprintf("viewer-%d", i%5), "", Event{EventType: "notification"})
+		}(i)
+	}
+	wg.Wait()
+}
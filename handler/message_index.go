@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Chat messages are searched in Firestore: each chat document keeps the
+// prefixes of its words in search_prefixes, and a search looks up the newest
+// messages of the rooms containing the most selective query term, then checks
+// the other terms. Messages from before search are given their prefixes by
+// the message_prefixes migration. The query needs a composite index on roomId,
+// search_prefixes (array-contains) and sendTime descending.
+
+var (
+	// messageCandidateLimit is how many messages containing the most
+	// selective term are looked up, newest first. Totals are capped by it.
+	messageCandidateLimit = 500
+
+	// messageSearchRoomBatch is how many rooms one "in" query covers.
+	messageSearchRoomBatch = 10
+)
+
+type IndexedMessage struct {
+	Id       string
+	RoomId   string
+	UserId   string
+	Nickname string
+	Text     string
+	SendTime time.Time
+}
+
+// Highlight is a matched range in a message text, counted in runes.
+type Highlight struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+type MessageHit struct {
+	Message    IndexedMessage
+	Highlights []Highlight
+}
+
+type token struct {
+	text   string
+	offset int
+	length int
+}
+
+// tokenize splits text into lower-cased words of letters and digits along
+// with their rune positions.
+func tokenize(text string) []token {
+	var tokens []token
+	var current []rune
+	start := 0
+	pos := 0
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, token{text: string(current), offset: start, length: len(current)})
+			current = current[:0]
+		}
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if len(current) == 0 {
+				start = pos
+			}
+			current = append(current, unicode.ToLower(r))
+		} else {
+			flush()
+		}
+		pos++
+	}
+	flush()
+	return tokens
+}
+
+// searchMessages returns the messages in roomIds matching every term of query
+// as a word prefix, newest first, skipping offset hits and returning at most
+// limit. The total number of hits is returned alongside; capped reports that
+// more messages contain the terms than were looked up, so total is a lower
+// bound.
+func searchMessages(query string, roomIds []string, offset int, limit int) (hits []MessageHit, total int, capped bool, err error) {
+	terms := tokenize(query)
+	if len(terms) == 0 || len(roomIds) == 0 {
+		return nil, 0, false, nil
+	}
+
+	var candidates []IndexedMessage
+	for start := 0; start < len(roomIds); start += messageSearchRoomBatch {
+		end := start + messageSearchRoomBatch
+		if end > len(roomIds) {
+			end = len(roomIds)
+		}
+		docs, err := dbClient.Collection("chat").
+			Where("roomId", "in", roomIds[start:end]).
+			Where("search_prefixes", "array-contains", selectiveTerm(terms)).
+			OrderBy("sendTime", firestore.Desc).
+			Limit(messageCandidateLimit).
+			Documents(ctx).GetAll()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if len(docs) == messageCandidateLimit {
+			capped = true
+		}
+		for _, doc := range docs {
+			candidates = append(candidates, indexedMessageFromDoc(doc))
+		}
+	}
+
+	// Every batch holds its newest messages, so the newest
+	// messageCandidateLimit of all batches miss none.
+	sortMessagesNewestFirst(candidates)
+	if len(candidates) > messageCandidateLimit {
+		candidates = candidates[:messageCandidateLimit]
+		capped = true
+	}
+
+	hits, total = matchMessages(candidates, terms, offset, limit)
+	return hits, total, capped, nil
+}
+
+func indexedMessageFromDoc(doc *firestore.DocumentSnapshot) IndexedMessage {
+	msg := IndexedMessage{Id: doc.Ref.ID}
+	msg.RoomId, _ = doc.Data()["roomId"].(string)
+	msg.UserId, _ = doc.Data()["username"].(string)
+	msg.Nickname, _ = doc.Data()["nickname"].(string)
+	msg.Text, _ = doc.Data()["text"].(string)
+	msg.SendTime, _ = doc.Data()["sendTime"].(time.Time)
+	return msg
+}
+
+// matchMessages filters messages down to the ones containing every term as a
+// word prefix, so "영상" also finds "영상이", and returns the requested page of
+// them, newest first, with the total number of hits.
+func matchMessages(messages []IndexedMessage, terms []token, offset int, limit int) ([]MessageHit, int) {
+	var hits []MessageHit
+	for _, msg := range messages {
+		if !containsTerms(msg.Text, terms) {
+			continue
+		}
+		hits = append(hits, MessageHit{Message: msg, Highlights: highlight(msg.Text, terms)})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return newerMessage(hits[i].Message, hits[j].Message)
+	})
+
+	total := len(hits)
+	if offset >= total {
+		return nil, total
+	}
+	hits = hits[offset:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, total
+}
+
+func sortMessagesNewestFirst(messages []IndexedMessage) {
+	sort.Slice(messages, func(i, j int) bool {
+		return newerMessage(messages[i], messages[j])
+	})
+}
+
+func newerMessage(a IndexedMessage, b IndexedMessage) bool {
+	if a.SendTime.Equal(b.SendTime) {
+		return a.Id < b.Id
+	}
+	return a.SendTime.After(b.SendTime)
+}
+
+// containsTerms reports whether every term starts a word of text.
+func containsTerms(text string, terms []token) bool {
+	words := tokenize(text)
+	for _, term := range terms {
+		found := false
+		for _, t := range words {
+			if strings.HasPrefix(t.text, term.text) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight returns the ranges of words in text that start with any of terms.
+func highlight(text string, terms []token) []Highlight {
+	var highlights []Highlight
+	for _, t := range tokenize(text) {
+		for _, term := range terms {
+			if strings.HasPrefix(t.text, term.text) {
+				highlights = append(highlights, Highlight{Offset: t.offset, Length: len([]rune(term.text))})
+				break
+			}
+		}
+	}
+	return highlights
+}
+
+// migrateMessagePrefixes writes search_prefixes on the chat messages saved
+// before messages were searchable. Messages that have them are skipped, so
+// the migration can be interrupted and run again.
+func migrateMessagePrefixes() error {
+	iter := dbClient.Collection("chat").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := doc.Data()["search_prefixes"]; ok {
+			continue
+		}
+		text, _ := doc.Data()["text"].(string)
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "search_prefixes", Value: wordPrefixes(text)},
+		}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Hello, 영상이 좋아요! GO2go")
+	want := []token{
+		{text: "hello", offset: 0, length: 5},
+		{text: "영상이", offset: 7, length: 3},
+		{text: "좋아요", offset: 11, length: 3},
+		{text: "go2go", offset: 16, length: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %+v, want %+v", got, want)
+	}
+	if got := tokenize(" ... "); got != nil {
+		t.Errorf("tokenize of punctuation = %+v", got)
+	}
+}
+
+func fixtureMessages() []IndexedMessage {
+	base := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	return []IndexedMessage{
+		{Id: "m1", RoomId: "r1", Text: "오늘 영상이 좋네요", SendTime: base},
+		{Id: "m2", RoomId: "r1", Text: "Great video today", SendTime: base.Add(time.Minute)},
+		{Id: "m3", RoomId: "r2", Text: "video games", SendTime: base.Add(2 * time.Minute)},
+		{Id: "m4", RoomId: "r2", Text: "no match here", SendTime: base.Add(3 * time.Minute)},
+		{Id: "m5", RoomId: "r1", Text: "VIDEO!", SendTime: base.Add(time.Minute)},
+	}
+}
+
+func messageIds(hits []MessageHit) []string {
+	ids := []string{}
+	for _, hit := range hits {
+		ids = append(ids, hit.Message.Id)
+	}
+	return ids
+}
+
+func TestMatchMessages(t *testing.T) {
+	queries := map[string][]string{
+		"video":       {"m3", "m2", "m5"},
+		"vid today":   {"m2"},
+		"영상":          {"m1"},
+		"ideo":        {},
+		"video games": {"m3"},
+	}
+	for query, want := range queries {
+		hits, total := matchMessages(fixtureMessages(), tokenize(query), 0, 20)
+		if got := messageIds(hits); !reflect.DeepEqual(got, want) || total != len(want) {
+			t.Errorf("%q matched %v (total %d), want %v", query, got, total, want)
+		}
+	}
+}
+
+func TestMatchMessagesHighlights(t *testing.T) {
+	hits, _ := matchMessages(fixtureMessages(), tokenize("영상 좋"), 0, 20)
+	if len(hits) != 1 {
+		t.Fatalf("%d hits, want 1", len(hits))
+	}
+	want := []Highlight{{Offset: 3, Length: 2}, {Offset: 7, Length: 1}}
+	if !reflect.DeepEqual(hits[0].Highlights, want) {
+		t.Errorf("highlights %+v, want %+v", hits[0].Highlights, want)
+	}
+}
+
+func TestMatchMessagesPages(t *testing.T) {
+	terms := tokenize("video")
+	everything, total := matchMessages(fixtureMessages(), terms, 0, 20)
+
+	var paged []MessageHit
+	for offset := 0; offset < total; offset += 2 {
+		hits, pageTotal := matchMessages(fixtureMessages(), terms, offset, 2)
+		if pageTotal != total {
+			t.Errorf("offset %d: total %d, want %d", offset, pageTotal, total)
+		}
+		paged = append(paged, hits...)
+	}
+	if !reflect.DeepEqual(messageIds(paged), messageIds(everything)) {
+		t.Errorf("pages %v, want %v", messageIds(paged), messageIds(everything))
+	}
+
+	if hits, pageTotal := matchMessages(fixtureMessages(), terms, 10, 2); len(hits) != 0 || pageTotal != total {
+		t.Errorf("past the end: %d hits, total %d", len(hits), pageTotal)
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Migrations are one-off jobs that bring data written by earlier versions up
+// to date. They are run from the command line with -migrate <name> instead of
+// on startup, and record their progress in migrations/{name}: a migration
+// that completed is not run again, and one that is running is not started a
+// second time until its lease expires.
+var migrations = map[string]func() error{
+	"like_records":          migrateLegacyLikes,
+	"chat_counts":           migrateChatCounts,
+	"follow_edges":          migrateFollowLists,
+	"user_stats":            migrateUserStats,
+	"search_index":          migrateSearchIndex,
+	"nickname_reservations": migrateNicknameReservations,
+	"message_prefixes":      migrateMessagePrefixes,
+}
+
+var (
+	migrationLease = time.Hour
+
+	// migrationNotDoneTTL is how long a migration found unfinished is assumed
+	// to still be unfinished by code that falls back to legacy data.
+	migrationNotDoneTTL = time.Minute
+
+	migrationDoneCache     = make(map[string]bool)
+	migrationCheckedAt     = make(map[string]time.Time)
+	migrationDoneCacheLock = sync.Mutex{}
+
+	errMigrationRunning = errors.New("migration is already running")
+)
+
+func migrationRef(name string) *firestore.DocumentRef {
+	return dbClient.Collection("migrations").Doc(name)
+}
+
+// RunMigration runs the named migration unless it already completed.
+func RunMigration(name string) error {
+	migrate, ok := migrations[name]
+	if !ok {
+		return fmt.Errorf("unknown migration %q", name)
+	}
+
+	ref := migrationRef(name)
+	done := false
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if doc.Data()["done"] == true {
+				done = true
+				return nil
+			}
+			if startedAt, ok := doc.Data()["started_at"].(time.Time); ok && time.Since(startedAt) < migrationLease {
+				return errMigrationRunning
+			}
+		}
+		return tx.Set(ref, map[string]interface{}{
+			"done":       false,
+			"started_at": time.Now(),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("migration %s already completed", name)
+		return nil
+	}
+
+	if err := migrate(); err != nil {
+		// Release the lease so the migration can be run again right away.
+		if _, releaseErr := ref.Delete(ctx); releaseErr != nil {
+			log.Printf("failed to release migration %s: %v", name, releaseErr)
+		}
+		return err
+	}
+
+	_, err = ref.Set(ctx, map[string]interface{}{
+		"done":        true,
+		"finished_at": time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// isMigrationDone reports whether the named migration completed. Completion
+// is cached for good; an unfinished migration is checked again after
+// migrationNotDoneTTL.
+func isMigrationDone(name string) (bool, error) {
+	migrationDoneCacheLock.Lock()
+	if migrationDoneCache[name] || time.Since(migrationCheckedAt[name]) < migrationNotDoneTTL {
+		done := migrationDoneCache[name]
+		migrationDoneCacheLock.Unlock()
+		return done, nil
+	}
+	migrationDoneCacheLock.Unlock()
+
+	doc, err := migrationRef(name).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return false, err
+	}
+	done := err == nil && doc.Data()["done"] == true
+
+	migrationDoneCacheLock.Lock()
+	defer migrationDoneCacheLock.Unlock()
+	migrationDoneCache[name] = done
+	migrationCheckedAt[name] = time.Now()
+	return done, nil
+}
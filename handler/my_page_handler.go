@@ -3,66 +3,62 @@ package handler
 import (
 	"fmt"
 	"net/http"
-	"time"
 
-	"cloud.google.com/go/firestore"
+	"example.com/gobloc/pagination"
+
 	"github.com/gin-gonic/gin"
 )
 
 type MyPage struct {
-	Id       string  `firestore:"id" json:"id"`
-	Image    string  `firestore:"image" json:"image"`
-	Nickname string  `firestore:"nickname" json:"nickname"`
-	Intro    string  `firestore:"introduction" json:"introduction"`
-	Videos   []Video `json:"videos"`
+	Id         string  `firestore:"id" json:"id"`
+	Image      string  `firestore:"image" json:"image"`
+	Nickname   string  `firestore:"nickname" json:"nickname"`
+	Intro      string  `firestore:"introduction" json:"introduction"`
+	Videos     []Video `json:"videos"`
+	NextCursor string  `json:"next_cursor"`
+	HasMore    bool    `json:"has_more"`
 }
 
 func GetMyPage(c *gin.Context) {
 	userID := c.DefaultQuery("user_id", "")
 
-	user, err := getUserFromDatabase(userID)
+	scope := "mypage:" + userID
+	req, ok := parsePageRequest(c, scope, videoPageLimits)
+	if !ok {
+		return
+	}
+
+	user, err := getUserFromDatabase(userID, scope, req)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to fetch user videos: %v", err),
-		})
+		respondPageError(c, err, fmt.Sprintf("Failed to fetch user videos: %v", err))
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
 
-func getUserFromDatabase(userID string) (MyPage, error) {
-	var videos []Video
-	docs, err := dbClient.Collection("videos").Where("uploader", "==", userID).OrderBy("upload_time", firestore.Desc).Documents(ctx).GetAll()
+// getUserFromDatabase returns the user's profile with a page of their
+// videos. The page fields follow the list envelope.
+func getUserFromDatabase(userID string, scope string, req pagination.Request) (MyPage, error) {
+	videos, nextCursor, err := getUserVideosFromDatabase(userID, scope, req)
 	if err != nil {
 		return MyPage{}, err
 	}
 
-	for _, doc := range docs {
-		video := Video{
-			Id:       doc.Ref.ID,
-			Title:    doc.Data()["title"].(string),
-			Uploader: doc.Data()["uploader"].(string),
-			Url:      doc.Data()["url"].(string),
-			//LikeCount:   int(doc.Data()["like_count"].(int64)),
-			Upload_time: doc.Data()["upload_time"].(time.Time).Format(time.RFC3339),
-			Thumbnail:   doc.Data()["thumbnail"].(string),
-		}
-		videos = append(videos, video)
-	}
-
 	user, err2 := dbClient.Collection("users").Doc(userID).Get(ctx)
 	if err2 != nil {
 		return MyPage{}, err2
 	}
 
 	mypage := MyPage{
-		Id:       user.Data()["id"].(string),
-		Image:    user.Data()["image"].(string),
-		Nickname: user.Data()["nickname"].(string),
-		Intro:    user.Data()["introduction"].(string),
-		Videos:   videos,
+		Id:         user.Data()["id"].(string),
+		Image:      user.Data()["image"].(string),
+		Nickname:   user.Data()["nickname"].(string),
+		Intro:      user.Data()["introduction"].(string),
+		Videos:     videos,
+		NextCursor: nextCursor,
+		HasMore:    nextCursor != "",
 	}
 
 	return mypage, nil
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -20,35 +21,72 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: chatSubprotocols,
 }
 
 type Event struct {
-	EventType    string     `json:"event_type"`
-	Message      *Message   `json:"message,omitempty"`
-	FirstMessage *[]Message `json:"first_message,omitempty"`
-	TotalLike    *int       `json:"total_like,omitempty"`
-	UserLike     *bool      `json:"user_like,omitempty"`
-	UserId       *string    `json:"user_id,omitempty"`
+	EventType    string        `json:"event_type"`
+	Message      *Message      `json:"message,omitempty"`
+	FirstMessage *[]Message    `json:"first_message,omitempty"`
+	TotalLike    *int          `json:"total_like,omitempty"`
+	UserLike     *bool         `json:"user_like,omitempty"`
+	UserId       *string       `json:"user_id,omitempty"`
+	ReadTime     *string       `json:"read_time,omitempty"`
+	Notification *Notification `json:"notification,omitempty"`
+
+	// RequestId is set by clients on the events they send and echoed on the
+	// ack or error event answering it.
+	RequestId       *string       `json:"request_id,omitempty"`
+	Error           *EventError   `json:"error,omitempty"`
+	ProtocolVersion *int          `json:"protocol_version,omitempty"`
+	RoomSettings    *RoomSettings `json:"room_settings,omitempty"`
+	PinnedMessages  *[]Message    `json:"pinned_messages,omitempty"`
+
+	// sender is the connection the event originated from. Events that only
+	// concern other participants (read receipts) are not echoed back to it.
+	sender *User
+	// recipient restricts delivery to the connections of a single user.
+	recipient string
 }
 
 type User struct {
 	Conn   *websocket.Conn
 	RoomId string
+	UserId string
+	// Events buffers outgoing events for clients connected over SSE or
+	// long polling instead of a websocket.
+	Events chan Event
+	// codec is the framing negotiated for Conn.
+	codec eventCodec
+	// version is the negotiated protocol version.
+	version   int
+	writeLock sync.Mutex
 }
 
 type Message struct {
-	UserImage  string `json:"user_image"`
-	UserId     string `json:"username"`
-	Nickname   string `json:"nickname"`
-	Text       string `json:"text"`
-	RoomId     string `json:"room_id"`
-	TotalCount int    `json:"total_count"`
-	SendTime   string `json:"sendTime"`
+	Id          string       `json:"id"`
+	UserImage   string       `json:"user_image"`
+	UserId      string       `json:"username"`
+	Nickname    string       `json:"nickname"`
+	Text        string       `json:"text"`
+	RoomId      string       `json:"room_id"`
+	TotalCount  int          `json:"total_count"`
+	SendTime    string       `json:"sendTime"`
+	Mentions    []Mention    `json:"mentions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
 type Room struct {
+	Id        string
 	Users     map[*User]bool
 	Broadcast chan Event
+	// done is closed when the last user leaves and the room is removed,
+	// which stops its broadcast loop.
+	done chan struct{}
+
+	// state caches the uploader and settings of the room's video.
+	state     *roomState
+	stateLock sync.Mutex
 }
 
 var rooms = make(map[string]*Room)
@@ -56,43 +94,166 @@ var lock = sync.RWMutex{}
 
 func handleEvents(room *Room) {
 	for {
-		event := <-room.Broadcast
+		var event Event
+		select {
+		case event = <-room.Broadcast:
+		case <-room.done:
+			return
+		}
 
+		var failed []*User
 		lock.RLock()
 		for user := range room.Users {
-			err := user.Conn.WriteJSON(event)
+			if !shouldDeliver(user, event) {
+				continue
+			}
+			err := user.send(event)
 			if err != nil {
 				fmt.Printf("error: %v", err)
-				user.Conn.Close()
-				delete(room.Users, user)
+				failed = append(failed, user)
 			}
 		}
 		lock.RUnlock()
+
+		// Other rooms' loops and sendToUser read room maps under the read
+		// lock, so users are only removed under the write lock.
+		if len(failed) > 0 {
+			closeUsers(room, failed)
+		}
 	}
 }
 
-func HandleWebSocket(c *gin.Context) {
-	roomId := c.Query("room_id")
-	userId := c.Query("user_id")
-
+// closeUsers removes the users from the room and closes their connections.
+// Users already removed are skipped, so each connection is closed once.
+func closeUsers(room *Room, users []*User) {
 	lock.Lock()
-	if _, ok := rooms[roomId]; !ok {
-		rooms[roomId] = &Room{
-			Users:     make(map[*User]bool),
-			Broadcast: make(chan Event),
+	defer lock.Unlock()
+
+	for _, user := range users {
+		if room.Users[user] {
+			removeUserLocked(room, user)
+			user.close()
 		}
-		go handleEvents(rooms[roomId])
 	}
-	lock.Unlock()
+}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		fmt.Printf("egrror: %v\n", err)
-		return
+// removeUserLocked removes the user from the room, and the room once it is
+// empty. The caller must hold the write lock.
+func removeUserLocked(room *Room, user *User) {
+	delete(room.Users, user)
+	if len(room.Users) == 0 && rooms[room.Id] == room {
+		delete(rooms, room.Id)
+		close(room.done)
+	}
+}
+
+// broadcast sends event to the users of the room. It returns without sending
+// once the room has been removed.
+func (room *Room) broadcast(event Event) {
+	select {
+	case room.Broadcast <- event:
+	case <-room.done:
+	}
+}
+
+// send writes event to the user's websocket, or queues it for SSE and
+// long-polling clients that have no websocket.
+func (user *User) send(event Event) error {
+	if user.Conn != nil {
+		messageType, data, err := user.codec.encodeEvent(event)
+		if err != nil {
+			return err
+		}
+
+		// Broadcasts and direct replies are written from different goroutines.
+		user.writeLock.Lock()
+		defer user.writeLock.Unlock()
+		return user.Conn.WriteMessage(messageType, data)
+	}
+
+	select {
+	case user.Events <- event:
+		return nil
+	default:
+		return fmt.Errorf("event buffer of %s is full", user.UserId)
+	}
+}
+
+func (user *User) close() {
+	if user.Conn != nil {
+		user.Conn.Close()
+	} else {
+		close(user.Events)
+	}
+}
+
+func shouldDeliver(user *User, event Event) bool {
+	if event.EventType == "read_receipt" && user == event.sender {
+		return false
+	}
+	if event.recipient != "" && user.UserId != event.recipient {
+		return false
 	}
+	return true
+}
 
-	user := &User{Conn: conn, RoomId: roomId}
-	rooms[roomId].Users[user] = true
+func newRoom(roomId string) *Room {
+	return &Room{
+		Id:        roomId,
+		Users:     make(map[*User]bool),
+		Broadcast: make(chan Event),
+		done:      make(chan struct{}),
+	}
+}
+
+// joinRoom adds the user to the room, creating the room and starting its
+// broadcast loop when nobody is in it yet.
+func joinRoom(roomId string, user *User) *Room {
+	lock.Lock()
+	defer lock.Unlock()
+
+	room, ok := rooms[roomId]
+	if !ok {
+		room = newRoom(roomId)
+		rooms[roomId] = room
+		go handleEvents(room)
+	}
+	room.Users[user] = true
+	return room
+}
+
+// lookupRoom returns the room if somebody is in it, or nil.
+func lookupRoom(roomId string) *Room {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return rooms[roomId]
+}
+
+// detachedRoom returns the room if somebody is in it, or otherwise a room
+// that is not registered and drops what is broadcast to it. It serves events
+// posted to rooms nobody is connected to without creating them.
+func detachedRoom(roomId string) *Room {
+	if room := lookupRoom(roomId); room != nil {
+		return room
+	}
+	room := newRoom(roomId)
+	close(room.done)
+	return room
+}
+
+// broadcastToRoom sends event to the users of the room, if there are any.
+func broadcastToRoom(roomId string, event Event) {
+	if room := lookupRoom(roomId); room != nil {
+		room.broadcast(event)
+	}
+}
+
+// initialEvents returns the events sent to a client when it joins a room:
+// the chat history with the pinned messages, the room settings and the like
+// state.
+func initialEvents(roomId string, userId string) []Event {
+	var events []Event
 
 	// Load chat history
 	chatHistory, err := loadChatHistory(roomId)
@@ -100,23 +261,33 @@ func HandleWebSocket(c *gin.Context) {
 		fmt.Printf("error: %v\n", err)
 	} else {
 		event := Event{
-			EventType: "first_message",
-			// Message:   nil,
-			FirstMessage: nil,
+			EventType:    "first_message",
+			FirstMessage: &chatHistory,
 		}
-		event.FirstMessage = &chatHistory
-		conn.WriteJSON(event)
+		pinnedMessages, err := loadPinnedMessages(roomId)
+		if err != nil {
+			log.Printf("failed to load pinned messages: %v", err)
+		} else {
+			event.PinnedMessages = &pinnedMessages
+		}
+		events = append(events, event)
 		println("message:", len(chatHistory), "roomId", roomId)
-		// for _, msg := range chatHistory {
-		// 	event.Message = &msg
-		// 	conn.WriteJSON(event)
-		// 	println("message:", len(chatHistory), "roomId", roomId)
-		// }
+	}
+
+	videoDoc, err := dbClient.Collection("videos").Doc(roomId).Get(ctx)
+	if err != nil {
+		log.Printf("failed to load room settings: %v", err)
+	} else {
+		settings := roomSettingsFromFirestore(videoDoc.Data()["room_settings"])
+		events = append(events, Event{
+			EventType:    "room_settings",
+			RoomSettings: &settings,
+		})
 	}
 
 	// Send total likes
-	totalLikes, err := getTotalLikes(roomId)
-	userLiked, err2 := checkUserLikedVideo(userId, roomId)
+	totalLikes, err := getLikeCount(roomId)
+	userLiked, err2 := isVideoLiked(userId, roomId)
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
 	}
@@ -124,88 +295,150 @@ func HandleWebSocket(c *gin.Context) {
 		fmt.Printf("error: %v\n", err2)
 	}
 
-	event := Event{
+	events = append(events, Event{
 		EventType: "first_like",
 		TotalLike: &totalLikes,
 		UserLike:  &userLiked,
 		UserId:    &userId,
+	})
+	println("first_lLike:", totalLikes, userLiked, len(chatHistory), roomId)
+
+	return events
+}
+
+func HandleWebSocket(c *gin.Context) {
+	roomId := c.Query("room_id")
+	userId := c.Query("user_id")
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
 	}
 
-	conn.WriteJSON(event)
-	println("first_lLike:", totalLikes, userLiked, len(chatHistory), roomId)
+	version, codec := negotiateSubprotocol(conn.Subprotocol())
+	user := &User{Conn: conn, RoomId: roomId, UserId: userId, codec: codec, version: version}
+
+	if version >= protocolV2 {
+		user.send(helloEvent(version))
+	}
+	room := joinRoom(roomId, user)
+
+	if err := ensureReadCursor(userId, roomId); err != nil {
+		log.Printf("failed to create read cursor: %v", err)
+	}
+
+	for _, event := range initialEvents(roomId, userId) {
+		user.send(event)
+	}
 
 	for {
-		var event Event
-		err := conn.ReadJSON(&event)
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			fmt.Printf("error: %v\n", err)
+			log.Printf("websocket read failed: %v", err)
 			removeUserFromRoom(roomId, user)
 			break
 		}
 
-		switch event.EventType {
-		case "message":
-			if event.Message != nil {
-				// Save new message to Firestore
-				saveMessageToFirestore(*event.Message, roomId)
-
-				// Update total count
-				roomDocCount, err := getMessageDocCount(roomId)
-				if err != nil {
-					fmt.Printf("error: %v\n", err)
-				} else {
-					event.Message.TotalCount = roomDocCount
-				}
-				event.Message.SendTime = time.Now().Format(time.RFC3339)
-				rooms[roomId].Broadcast <- event
-			}
-		case "like":
-			userId := event.UserId
-			likeEvent, err := handleLikeEvent(*userId, roomId)
-			if err != nil {
-				fmt.Printf("error: %v\n", err)
-			} else {
-				println(&likeEvent.TotalLike)
-				rooms[roomId].Broadcast <- *likeEvent
-			}
+		var event Event
+		var reply *Event
+		if err := codec.decodeEvent(messageType, data, &event); err != nil {
+			reply = errorEvent(event, errorBadRequest, err.Error())
+		} else {
+			reply = handleClientEvent(room, user, event)
+		}
+
+		if reply != nil && user.version >= protocolV2 {
+			user.send(*reply)
 		}
 	}
 }
 
-func checkUserLikedVideo(userID string, videoID string) (bool, error) {
-	if userID == "" {
-		return false, nil
-	}
+// handleClientEvent processes an event sent by a client of the room, whichever
+// transport it arrived on, and broadcasts the outcome to the room. The returned
+// event, if any, answers the sender: an ack for message sends or an error.
+func handleClientEvent(room *Room, user *User, event Event) *Event {
+	roomId := user.RoomId
 
-	userLikeDoc, err := dbClient.Collection("user_likes").Doc(userID).Get(ctx)
-	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			// Create a new document with the specified user ID
-			_, err := dbClient.Collection("user_likes").Doc(userID).Set(ctx, map[string]interface{}{
-				"like_videos": map[string]bool{},
-			})
-			if err != nil {
-				return false, err
-			}
-			return false, nil
+	switch event.EventType {
+	case "message":
+		if event.Message == nil || (event.Message.Text == "" && len(event.Message.Attachments) == 0) {
+			return errorEvent(event, errorBadRequest, "message is empty")
 		}
-		return false, err
-	}
-	likedVideos := userLikeDoc.Data()["like_videos"].(map[string]interface{})
-	liked, ok := likedVideos[videoID].(bool)
-	if !ok {
+		if err := validateAttachments(event.Message.Attachments); err != nil {
+			return errorEvent(event, errorInvalidAttachment, err.Error())
+		}
+		reply, cancelSlowMode := checkRoomSettings(room, user, event)
+		if reply != nil {
+			return reply
+		}
+
+		mentions, err := resolveMentions(event.Message.Text)
+		if err != nil {
+			log.Printf("failed to resolve mentions: %v", err)
+		}
+		event.Message.Mentions = mentions
 
-		liked = false
+		// Save new message to Firestore
+		messageId, sendTime, err := saveMessageToFirestore(*event.Message, roomId)
+		if err != nil {
+			log.Printf("failed to save message: %v", err)
+			cancelSlowMode()
+			return errorEvent(event, errorSaveFailed, "failed to save message")
+		}
+		event.Message.Id = messageId
+		event.Message.SendTime = formatSendTime(sendTime)
+		room.broadcast(event)
+
+		if err := notifyMentions(*event.Message, roomId); err != nil {
+			log.Printf("failed to notify mentions: %v", err)
+		}
+
+		return &Event{
+			EventType: "ack",
+			RequestId: event.RequestId,
+			Message:   event.Message,
+		}
+	case "like":
+		userId := user.UserId
+		if event.UserId != nil {
+			userId = *event.UserId
+		}
+		if userId == "" {
+			return errorEvent(event, errorBadRequest, "user_id is missing")
+		}
+		likeEvent, err := handleLikeEvent(userId, roomId, event)
+		if err != nil {
+			log.Printf("failed to like %s: %v", roomId, err)
+			return errorEvent(event, errorLikeFailed, "failed to update like")
+		}
+		room.broadcast(*likeEvent)
+	case "read":
+		receipt, err := handleReadEvent(user, event)
+		if err != nil {
+			log.Printf("failed to update read cursor: %v", err)
+			return errorEvent(event, errorReadFailed, err.Error())
+		}
+		room.broadcast(*receipt)
+	case "pin", "unpin":
+		pinned, reply := handlePinEvent(user, event)
+		if reply != nil {
+			return reply
+		}
+		room.broadcast(*pinned)
+	default:
+		return errorEvent(event, errorUnknownEvent, fmt.Sprintf("unknown event type: %s", event.EventType))
 	}
-	return liked, nil
+
+	return nil
 }
 
 func removeUserFromRoom(roomId string, user *User) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	if room, ok := rooms[roomId]; ok {
-		delete(room.Users, user)
+	if room, ok := rooms[roomId]; ok && room.Users[user] {
+		removeUserLocked(room, user)
 	}
 }
 
@@ -220,13 +453,16 @@ func loadChatHistory(roomId string) ([]Message, error) {
 
 	for _, doc := range docs {
 		msg := Message{
-			UserImage:  doc.Data()["user_image"].(string),
-			UserId:     doc.Data()["username"].(string),
-			Nickname:   doc.Data()["nickname"].(string),
-			Text:       doc.Data()["text"].(string),
-			RoomId:     doc.Data()["roomId"].(string),
-			TotalCount: len(docs),
-			SendTime:   doc.Data()["sendTime"].(time.Time).Format(time.RFC3339),
+			Id:          doc.Ref.ID,
+			UserImage:   doc.Data()["user_image"].(string),
+			UserId:      doc.Data()["username"].(string),
+			Nickname:    doc.Data()["nickname"].(string),
+			Text:        doc.Data()["text"].(string),
+			RoomId:      doc.Data()["roomId"].(string),
+			TotalCount:  len(docs),
+			SendTime:    formatSendTime(doc.Data()["sendTime"].(time.Time)),
+			Mentions:    mentionsFromFirestore(doc.Data()["mentions"]),
+			Attachments: attachmentsFromFirestore(doc.Data()["attachments"]),
 		}
 		messages = append(messages, msg)
 	}
@@ -234,123 +470,103 @@ func loadChatHistory(roomId string) ([]Message, error) {
 	return messages, nil
 }
 
-func saveMessageToFirestore(msg Message, roomId string) error {
-	_, _, err := dbClient.Collection("chat").Add(ctx, map[string]interface{}{
-		"username":   msg.UserId,
-		"text":       msg.Text,
-		"nickname":   msg.Nickname,
-		"user_image": msg.UserImage,
-		"roomId":     roomId,
-		"sendTime":   time.Now(),
-	})
-
-	return err
-}
-
-func getTotalLikes(roomId string) (int, error) {
-	doc, err := dbClient.Collection("videos").Doc(roomId).Get(ctx)
-	if err != nil {
-		return 0, err
+// saveMessageToFirestore stores msg and returns its id and send time. The send
+// time is cut to the microseconds Firestore keeps, so the time clients see is
+// the one stored.
+func saveMessageToFirestore(msg Message, roomId string) (string, time.Time, error) {
+	sendTime := time.Now().Truncate(time.Microsecond)
+	docRef := dbClient.Collection("chat").NewDoc()
+	data := map[string]interface{}{
+		"username":        msg.UserId,
+		"text":            msg.Text,
+		"nickname":        msg.Nickname,
+		"user_image":      msg.UserImage,
+		"roomId":          roomId,
+		"sendTime":        sendTime,
+		"mentions":        mentionsToFirestore(msg.Mentions),
+		"attachments":     attachmentsToFirestore(msg.Attachments),
+		"search_prefixes": wordPrefixes(msg.Text),
 	}
 
-	totalLikes := doc.Data()["like_count"].(int64)
-	return int(totalLikes), nil
-}
-
-func getMessageDocCount(roomId string) (int, error) {
-	query := dbClient.Collection("chat").Where("roomId", "==", roomId)
-	docs, err := query.Documents(ctx).GetAll()
+	done, err := isMigrationDone(chatCountsMigration)
 	if err != nil {
-		return 0, err
+		return "", time.Time{}, err
 	}
-
-	return len(docs), nil
-}
-
-func convertToMapStringBool(inputMap map[string]interface{}) map[string]bool {
-	outputMap := make(map[string]bool)
-	for key, value := range inputMap {
-		if boolValue, ok := value.(bool); ok {
-			outputMap[key] = boolValue
+	if !done {
+		if err := saveMessageCountingChat(docRef, data, roomId, sendTime); err != nil {
+			return "", time.Time{}, err
 		}
+		return docRef.ID, sendTime, nil
 	}
-	return outputMap
-}
-func handleLikeEvent(userId string, roomId string) (*Event, error) {
-	temp := false
 
-	userDocRef := dbClient.Collection("user_likes").Doc(userId)
-	videoDocRef := dbClient.Collection("videos").Doc(roomId)
+	if _, err := docRef.Create(ctx, data); err != nil {
+		return "", time.Time{}, err
+	}
 
-	userDoc, err := userDocRef.Get(ctx)
+	// The message is stored; failing to count it only leaves the counters
+	// one short. Rooms without a video document keep no count.
+	_, err = dbClient.Collection("videos").Doc(roomId).Update(ctx, []firestore.Update{
+		{Path: "chat_count", Value: firestore.Increment(1)},
+	})
 	if err != nil {
-		return nil, err
+		if status.Code(err) != codes.NotFound {
+			log.Printf("failed to count message in %s: %v", roomId, err)
+		}
+		return docRef.ID, sendTime, nil
 	}
-
-	likedVideosData := userDoc.Data()["like_videos"]
-	var likedVideos map[string]bool
-	if likedVideosData != nil {
-		likedVideos = convertToMapStringBool(likedVideosData.(map[string]interface{}))
-	} else {
-		likedVideos = make(map[string]bool)
+	if err := addTrendingActivity(roomId, sendTime, "chats", 1); err != nil {
+		log.Printf("failed to record trending chat in %s: %v", roomId, err)
 	}
-	// Like or unlike the video
-	if _, ok := likedVideos[roomId]; !ok {
-		likedVideos[roomId] = true
-
-		err = dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-			videoDoc, err := tx.Get(videoDocRef)
-			if err != nil {
-				return err
-			}
+	return docRef.ID, sendTime, nil
+}
 
-			likeCount := videoDoc.Data()["like_count"].(int64)
-			return tx.Update(videoDocRef, []firestore.Update{
-				{Path: "like_count", Value: likeCount + 1},
-			})
-		})
+// saveMessageCountingChat stores a message until the chat_counts migration
+// completed. Videos may still lack a chat_count then, and incrementing the
+// missing field would start them from zero, so the message is written in a
+// transaction with the count of the stored messages.
+func saveMessageCountingChat(docRef *firestore.DocumentRef, data map[string]interface{}, roomId string, sendTime time.Time) error {
+	videoRef := dbClient.Collection("videos").Doc(roomId)
+	return dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		count, _, ok, err := countChatInTx(tx, videoRef)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		temp = true
-	} else {
-		delete(likedVideos, roomId)
-
-		err = dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-			videoDoc, err := tx.Get(videoDocRef)
-			if err != nil {
-				return err
-			}
-
-			likeCount := videoDoc.Data()["like_count"].(int64)
-			return tx.Update(videoDocRef, []firestore.Update{
-				{Path: "like_count", Value: likeCount - 1},
-			})
-		})
-		if err != nil {
-			return nil, err
+		if err := tx.Create(docRef, data); err != nil {
+			return err
 		}
-		temp = false
-	}
-
-	// Update user's liked videos
-	_, err = userDocRef.Update(ctx, []firestore.Update{
-		{Path: "like_videos", Value: likedVideos},
+		if !ok {
+			return nil
+		}
+		if err := recordTrendingActivity(tx, roomId, sendTime, "chats", 1); err != nil {
+			return err
+		}
+		return tx.Set(videoRef, map[string]interface{}{"chat_count": count + 1}, firestore.MergeAll)
 	})
-	if err != nil {
-		return nil, err
-	}
+}
 
-	// Get updated like count
-	totalLikes, err := getTotalLikes(roomId)
+func getMessageDocCount(roomId string) (int, error) {
+	return countQuery(dbClient.Collection("chat").Where("roomId", "==", roomId))
+}
+
+// handleLikeEvent applies a like event and returns the total_like event to
+// broadcast. Clients may send the desired state in user_like; without it the
+// like is toggled.
+func handleLikeEvent(userId string, roomId string, event Event) (*Event, error) {
+	var state LikeState
+	var err error
+	if event.UserLike != nil {
+		state, err = setLike(userId, roomId, *event.UserLike)
+	} else {
+		state, err = toggleLike(userId, roomId)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return &Event{
 		EventType: "total_like",
-		TotalLike: &totalLikes,
-		UserLike:  &temp,
+		TotalLike: &state.LikeCount,
+		UserLike:  &state.Liked,
 		UserId:    &userId,
 	}, nil
 }
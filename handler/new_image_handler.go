@@ -57,22 +57,37 @@ func processImage(bucket *storage.BucketHandle, imageFile string) (string, error
 		return "", fmt.Errorf("failed to decode image: %w", err)
 	}
 
+	optimizeImg, err := resizeAndEncodeImage(img, imageWidth)
+	if err != nil {
+		return "", err
+	}
+
+	return uploadJPEG(bucket, imageBucket, optimizeImg)
+}
+
+// resizeAndEncodeImage scales img to the given width, keeping its aspect
+// ratio, and encodes it as JPEG.
+func resizeAndEncodeImage(img image.Image, width int) ([]byte, error) {
 	// Resize image
-	resizedImg := resize.Resize(uint(imageWidth), 0, img, resize.Lanczos3)
+	resizedImg := resize.Resize(uint(width), 0, img, resize.Lanczos3)
 
 	// Optimize image
 	buf := new(bytes.Buffer)
-	err = imaging.Encode(buf, resizedImg, imaging.JPEG, imaging.JPEGQuality(80))
+	err := imaging.Encode(buf, resizedImg, imaging.JPEG, imaging.JPEGQuality(80))
 	if err != nil {
-		return "", fmt.Errorf("failed to optimize image: %w", err)
+		return nil, fmt.Errorf("failed to optimize image: %w", err)
 	}
-	optimizeImg := buf.Bytes()
+	return buf.Bytes(), nil
+}
 
+// uploadJPEG stores the encoded image under prefix with a random file name
+// and returns its public URL.
+func uploadJPEG(bucket *storage.BucketHandle, prefix string, optimizeImg []byte) (string, error) {
 	// Generate UUID for the file name
 	filename := uuid.New().String() + ".jpg"
 
 	// Upload file to Firebase storage
-	object := bucket.Object(imageBucket + filename)
+	object := bucket.Object(prefix + filename)
 	wc := object.NewWriter(context.Background())
 
 	// Use a buffered writer for improved performance
@@ -90,7 +105,7 @@ func processImage(bucket *storage.BucketHandle, imageFile string) (string, error
 		return "", fmt.Errorf("failed to close writer: %w", err)
 	}
 
-	imageURL := fmt.Sprintf("https://storage.googleapis.com/%s%s%s", bucketName, "/images/", filename)
+	imageURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s%s", bucketName, prefix, filename)
 	return imageURL, nil
 }
 
@@ -163,6 +178,7 @@ func HandleImageMultiUpload(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		invalidateUserInfo(obj.UserId)
 
 		// Add image data to Firestore
 		imageRef := dbClient.Collection("images").NewDoc()
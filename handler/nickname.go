@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	nicknameMinLength = 2
+	nicknameMaxLength = 20
+
+	// defaultNicknameAttempts is how many random handles signup tries before
+	// giving up.
+	defaultNicknameAttempts = 5
+)
+
+var (
+	// nicknamePattern accepts the nicknames mentionPattern can match, starting
+	// with a letter or digit.
+	nicknamePattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_]*(?:\.[\p{L}\p{N}_]+)*$`)
+
+	reservedNicknames = map[string]bool{
+		"admin":         true,
+		"administrator": true,
+		"root":          true,
+		"system":        true,
+		"support":       true,
+		"moderator":     true,
+		"official":      true,
+		"staff":         true,
+		"everyone":      true,
+		"here":          true,
+		"me":            true,
+		"null":          true,
+		"undefined":     true,
+	}
+)
+
+var (
+	errNicknameLength   = fmt.Errorf("nickname must be %d to %d characters", nicknameMinLength, nicknameMaxLength)
+	errNicknameCharset  = errors.New("nickname may only contain letters, digits, underscores and dots between them")
+	errNicknameReserved = errors.New("nickname is reserved")
+	errNicknameTaken    = errors.New("nickname is already taken")
+	errNicknameLocked   = errors.New("nicknames cannot be changed until existing nicknames are reserved")
+)
+
+// Nicknames are unique through reservation records in nicknames/{nickname}.
+// Users registered before reservations existed get theirs from the
+// nickname_reservations migration; until it completed, renames are refused
+// and new default handles are also checked against the users' nicknames.
+const nicknameReservationsMigration = "nickname_reservations"
+
+// validateNickname checks the nickname against the length, charset and
+// reserved word rules. It does not check whether the nickname is taken.
+func validateNickname(nickname string) error {
+	length := utf8.RuneCountInString(nickname)
+	if length < nicknameMinLength || length > nicknameMaxLength {
+		return errNicknameLength
+	}
+	if !nicknamePattern.MatchString(nickname) {
+		return errNicknameCharset
+	}
+	if reservedNicknames[strings.ToLower(nickname)] {
+		return errNicknameReserved
+	}
+	return nil
+}
+
+// isInvalidNickname reports whether err is one of the errors of
+// validateNickname.
+func isInvalidNickname(err error) bool {
+	return err == errNicknameLength || err == errNicknameCharset || err == errNicknameReserved
+}
+
+// nicknameRef is the reservation record of the nickname. Nicknames are
+// reserved case-insensitively.
+func nicknameRef(nickname string) *firestore.DocumentRef {
+	return dbClient.Collection("nicknames").Doc(strings.ToLower(nickname))
+}
+
+// reserveNickname moves the user's reservation from oldNickname to nickname
+// as part of tx, failing with errNicknameTaken if another user holds it.
+// oldNickname may be empty for users without a reservation.
+func reserveNickname(tx *firestore.Transaction, userId string, oldNickname string, nickname string) error {
+	ref := nicknameRef(nickname)
+	doc, err := tx.Get(ref)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	if err == nil && doc.Data()["user_id"] != userId {
+		return errNicknameTaken
+	}
+
+	var oldDoc *firestore.DocumentSnapshot
+	if oldNickname != "" && strings.ToLower(oldNickname) != strings.ToLower(nickname) {
+		oldDoc, err = tx.Get(nicknameRef(oldNickname))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+	}
+
+	if err := tx.Set(ref, map[string]interface{}{
+		"user_id":  userId,
+		"nickname": nickname,
+	}); err != nil {
+		return err
+	}
+	if oldDoc != nil && oldDoc.Exists() && oldDoc.Data()["user_id"] == userId {
+		return tx.Delete(oldDoc.Ref)
+	}
+	return nil
+}
+
+// isNicknameAvailable reports whether userId may take the nickname.
+func isNicknameAvailable(userId string, nickname string) (bool, error) {
+	doc, err := nicknameRef(nickname).Get(ctx)
+	if err == nil {
+		return doc.Data()["user_id"] == userId, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return false, err
+	}
+
+	done, err := isMigrationDone(nicknameReservationsMigration)
+	if err != nil {
+		return false, err
+	}
+	if done {
+		return true, nil
+	}
+	users, err := dbClient.Collection("users").Where("nickname", "==", nickname).Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		if user.Ref.ID != userId {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// legacyNicknameTakenInTx reports, as part of tx, whether a user without a
+// reservation uses the nickname. It is false once the
+// nickname_reservations migration completed.
+func legacyNicknameTakenInTx(tx *firestore.Transaction, nickname string) (bool, error) {
+	done, err := isMigrationDone(nicknameReservationsMigration)
+	if err != nil || done {
+		return false, err
+	}
+	users, err := tx.Documents(dbClient.Collection("users").Where("nickname", "==", nickname).Limit(1)).GetAll()
+	if err != nil {
+		return false, err
+	}
+	return len(users) > 0, nil
+}
+
+// CheckNickname reports whether the nickname is valid and free for user_id.
+func CheckNickname(c *gin.Context) {
+	nickname := c.DefaultQuery("nickname", "")
+	userId := c.DefaultQuery("user_id", "")
+
+	if err := validateNickname(nickname); err != nil {
+		c.JSON(http.StatusOK, gin.H{"available": false, "reason": err.Error()})
+		return
+	}
+
+	available, err := isNicknameAvailable(userId, nickname)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !available {
+		c.JSON(http.StatusOK, gin.H{"available": false, "reason": errNicknameTaken.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"available": true})
+}
+
+// randomNickname returns a default handle of the form user12345678.
+func randomNickname() string {
+	return fmt.Sprintf("user%08d", rand.Intn(100000000))
+}
+
+// createUser registers the user with a free default handle, reserving it in
+// the same transaction.
+func createUser(userID string) (string, error) {
+	userRef := dbClient.Collection("users").Doc(userID)
+	for attempt := 0; attempt < defaultNicknameAttempts; attempt++ {
+		nickname := randomNickname()
+		err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			taken, err := legacyNicknameTakenInTx(tx, nickname)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return errNicknameTaken
+			}
+			if err := reserveNickname(tx, userID, "", nickname); err != nil {
+				return err
+			}
+			return tx.Create(userRef, map[string]interface{}{
+				"id":           userID,
+				"image":        "",
+				"thumbnail":    "",
+				"nickname":     nickname,
+				"introduction": "hello",
+			})
+		})
+		if err == errNicknameTaken {
+			continue
+		}
+		return nickname, err
+	}
+	return "", errNicknameTaken
+}
+
+// migrateNicknameReservations reserves the nicknames of users registered
+// before reservations existed. When several users share a nickname the first
+// one read keeps it; the others are logged and must pick a new one.
+func migrateNicknameReservations() error {
+	users, err := dbClient.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, user := range users {
+		nickname, _ := user.Data()["nickname"].(string)
+		if nickname == "" {
+			continue
+		}
+		err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			return reserveNickname(tx, user.Ref.ID, "", nickname)
+		})
+		switch {
+		case err == errNicknameTaken:
+			log.Printf("nickname %q of %s is taken by another user", nickname, user.Ref.ID)
+		case err != nil:
+			log.Printf("failed to reserve nickname %q of %s: %v", nickname, user.Ref.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d nicknames were not reserved", failed)
+	}
+	return nil
+}
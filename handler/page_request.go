@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parsePageRequest reads the cursor and page_size query parameters for the
+// list named by scope, answering 400 when they are invalid.
+func parsePageRequest(c *gin.Context, scope string, limits pagination.Limits) (pagination.Request, bool) {
+	req, err := pagination.Parse(scope, c.Query("cursor"), c.Query("page_size"), limits)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return pagination.Request{}, false
+	}
+	return req, true
+}
+
+// cursorDoc reads the document a page starts after, or returns nil for the
+// first page. A cursor whose document was deleted is invalid.
+func cursorDoc(collection string, req pagination.Request) (*firestore.DocumentSnapshot, error) {
+	if req.After == "" {
+		return nil, nil
+	}
+	doc, err := dbClient.Collection(collection).Doc(req.After).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, pagination.ErrInvalidCursor
+	}
+	return doc, err
+}
+
+// respondPageError answers a failed list request.
+func respondPageError(c *gin.Context, err error, message string) {
+	if err == pagination.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// pageQuery runs query for the requested page, starting after the cursor
+// document in collection, and returns the page's documents and the cursor of
+// the next page, which is empty on the last page.
+func pageQuery(query firestore.Query, collection string, scope string, req pagination.Request) ([]*firestore.DocumentSnapshot, string, error) {
+	cursor, err := cursorDoc(collection, req)
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor != nil {
+		query = query.StartAfter(cursor)
+	}
+
+	docs, err := query.Limit(req.Size + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(docs) <= req.Size {
+		return docs, "", nil
+	}
+	docs = docs[:req.Size]
+	return docs, pagination.After(scope, docs[len(docs)-1].Ref.ID), nil
+}
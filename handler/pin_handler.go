@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var maxPinnedMessages = 3
+
+var (
+	errNotUploader     = errors.New("only the uploader can pin messages")
+	errMessageNotFound = errors.New("message not found in this room")
+)
+
+// loadPinnedMessages returns the pinned messages of a room in pin order.
+func loadPinnedMessages(roomId string) ([]Message, error) {
+	videoDoc, err := dbClient.Collection("videos").Doc(roomId).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return loadMessagesByIds(stringsFromFirestore(videoDoc.Data()["pinned_messages"]))
+}
+
+func stringsFromFirestore(value interface{}) []string {
+	items, _ := value.([]interface{})
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if id, ok := item.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// loadMessagesByIds reads chat messages in one batch, skipping deleted ones.
+func loadMessagesByIds(ids []string) ([]Message, error) {
+	messages := []Message{}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, dbClient.Collection("chat").Doc(id))
+	}
+
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		messages = append(messages, Message{
+			Id:          doc.Ref.ID,
+			UserImage:   doc.Data()["user_image"].(string),
+			UserId:      doc.Data()["username"].(string),
+			Nickname:    doc.Data()["nickname"].(string),
+			Text:        doc.Data()["text"].(string),
+			RoomId:      doc.Data()["roomId"].(string),
+			SendTime:    formatSendTime(doc.Data()["sendTime"].(time.Time)),
+			Mentions:    mentionsFromFirestore(doc.Data()["mentions"]),
+			Attachments: attachmentsFromFirestore(doc.Data()["attachments"]),
+		})
+	}
+	return messages, nil
+}
+
+// setMessagePinned pins or unpins a message of the room on behalf of userId,
+// who must be the video's uploader. Pinning an already pinned message or
+// unpinning one that is not pinned changes nothing. When more than
+// maxPinnedMessages are pinned the oldest pin is dropped.
+func setMessagePinned(roomId string, userId string, messageId string, pinned bool) ([]Message, error) {
+	videoRef := dbClient.Collection("videos").Doc(roomId)
+	messageRef := dbClient.Collection("chat").Doc(messageId)
+
+	var pinnedIds []string
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		videoDoc, err := tx.Get(videoRef)
+		if err != nil {
+			return err
+		}
+		if videoDoc.Data()["uploader"].(string) != userId {
+			return errNotUploader
+		}
+
+		pinnedIds = stringsFromFirestore(videoDoc.Data()["pinned_messages"])
+		remaining := make([]string, 0, len(pinnedIds)+1)
+		for _, id := range pinnedIds {
+			if id != messageId {
+				remaining = append(remaining, id)
+			}
+		}
+
+		if pinned {
+			messageDoc, err := tx.Get(messageRef)
+			if err != nil || !messageDoc.Exists() || messageDoc.Data()["roomId"] != roomId {
+				return errMessageNotFound
+			}
+			remaining = append(remaining, messageId)
+			if len(remaining) > maxPinnedMessages {
+				remaining = remaining[len(remaining)-maxPinnedMessages:]
+			}
+		}
+
+		pinnedIds = remaining
+		return tx.Update(videoRef, []firestore.Update{
+			{Path: "pinned_messages", Value: pinnedIds},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return loadMessagesByIds(pinnedIds)
+}
+
+// handlePinEvent handles pin and unpin events sent over the chat transports
+// and returns the pinned event to broadcast.
+func handlePinEvent(user *User, event Event) (*Event, *Event) {
+	if event.Message == nil || event.Message.Id == "" {
+		return nil, errorEvent(event, errorBadRequest, "message id is missing")
+	}
+
+	pinnedMessages, err := setMessagePinned(user.RoomId, user.UserId, event.Message.Id, event.EventType == "pin")
+	if err == errNotUploader {
+		return nil, errorEvent(event, errorForbidden, err.Error())
+	}
+	if err == errMessageNotFound {
+		return nil, errorEvent(event, errorBadRequest, err.Error())
+	}
+	if err != nil {
+		return nil, errorEvent(event, errorPinFailed, "failed to update pinned messages")
+	}
+
+	return &Event{
+		EventType:      "pinned",
+		PinnedMessages: &pinnedMessages,
+	}, nil
+}
+
+func updatePinned(c *gin.Context, pinned bool) {
+	roomId := c.DefaultQuery("room_id", "")
+	userId := c.DefaultQuery("user_id", "")
+	messageId := c.DefaultQuery("message_id", "")
+
+	if roomId == "" || messageId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id and message_id are required"})
+		return
+	}
+
+	pinnedMessages, err := setMessagePinned(roomId, userId, messageId, pinned)
+	if err == errNotUploader {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err == errMessageNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	broadcastToRoom(roomId, Event{
+		EventType:      "pinned",
+		PinnedMessages: &pinnedMessages,
+	})
+
+	c.JSON(http.StatusOK, pinnedMessages)
+}
+
+func PinMessage(c *gin.Context) {
+	updatePinned(c, true)
+}
+
+func UnpinMessage(c *gin.Context) {
+	updatePinned(c, false)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/golang/groupcache/lru"
+)
+
+const (
+	profileCacheSize = 10000
+
+	// profileCacheTTL bounds how long a profile changed through another
+	// instance can be served stale.
+	profileCacheTTL = 5 * time.Minute
+)
+
+var (
+	profileCache     = newProfileCache()
+	profileCacheLock = sync.Mutex{}
+
+	// profileGeneration counts invalidations. A read that started before a
+	// user's profile was invalidated must not cache what it read, so
+	// invalidations leave a tombstone with their generation in the cache.
+	profileGeneration uint64
+	// profileEvictedGeneration is the newest generation of a tombstone the
+	// cache evicted. Reads older than it cannot tell whether they raced an
+	// invalidation and are not cached.
+	profileEvictedGeneration uint64
+)
+
+// cachedProfile is a cached profile, or a tombstone when invalidated is set.
+type cachedProfile struct {
+	info        UserInfo
+	cachedAt    time.Time
+	invalidated uint64
+}
+
+func newProfileCache() *lru.Cache {
+	cache := lru.New(profileCacheSize)
+	cache.OnEvicted = func(key lru.Key, value interface{}) {
+		if generation := value.(cachedProfile).invalidated; generation > profileEvictedGeneration {
+			profileEvictedGeneration = generation
+		}
+	}
+	return cache
+}
+
+// getUserInfos returns the profiles of the users, reading those missing from
+// the cache in one batch. Users that do not exist are left out.
+func getUserInfos(userIds []string) (map[string]UserInfo, error) {
+	infos, missing, generation := cachedUserInfos(userIds)
+	if len(missing) == 0 {
+		return infos, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(missing))
+	for _, id := range missing {
+		refs = append(refs, dbClient.Collection("users").Doc(id))
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	read := make(map[string]UserInfo, len(docs))
+	for _, user := range docs {
+		if !user.Exists() {
+			continue
+		}
+		info := userInfoFromDoc(user)
+		infos[user.Ref.ID] = info
+		read[user.Ref.ID] = info
+	}
+	storeUserInfos(read, generation, time.Now())
+	return infos, nil
+}
+
+// cachedUserInfos returns the cached profiles of the users, the ids missing
+// from the cache, and the generation to store the missing ones with once
+// they are read.
+func cachedUserInfos(userIds []string) (map[string]UserInfo, []string, uint64) {
+	infos := make(map[string]UserInfo)
+	var missing []string
+	seen := make(map[string]bool)
+
+	profileCacheLock.Lock()
+	defer profileCacheLock.Unlock()
+	for _, id := range userIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if value, ok := profileCache.Get(id); ok {
+			cached := value.(cachedProfile)
+			if cached.invalidated == 0 && time.Since(cached.cachedAt) < profileCacheTTL {
+				infos[id] = cached.info
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+	return infos, missing, profileGeneration
+}
+
+// storeUserInfos caches profiles read at the given generation, except those
+// invalidated since.
+func storeUserInfos(infos map[string]UserInfo, generation uint64, now time.Time) {
+	profileCacheLock.Lock()
+	defer profileCacheLock.Unlock()
+
+	if generation < profileEvictedGeneration {
+		return
+	}
+	for id, info := range infos {
+		if value, ok := profileCache.Get(id); ok && value.(cachedProfile).invalidated > generation {
+			continue
+		}
+		profileCache.Add(id, cachedProfile{info: info, cachedAt: now})
+	}
+}
+
+func userInfoFromDoc(user *firestore.DocumentSnapshot) UserInfo {
+	image, _ := user.Data()["image"].(string)
+	thumbnail, _ := user.Data()["thumbnail"].(string)
+	nickname, _ := user.Data()["nickname"].(string)
+	intro, _ := user.Data()["introduction"].(string)
+	return UserInfo{
+		Id:        user.Ref.ID,
+		Image:     image,
+		Thumbnail: thumbnail,
+		Nickname:  nickname,
+		Intro:     intro,
+	}
+}
+
+// invalidateUserInfo drops the user's cached profile after it changed.
+func invalidateUserInfo(userId string) {
+	profileCacheLock.Lock()
+	defer profileCacheLock.Unlock()
+	profileGeneration++
+	profileCache.Add(userId, cachedProfile{invalidated: profileGeneration})
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func resetProfileCache(t *testing.T, size int) {
+	t.Helper()
+	profileCacheLock.Lock()
+	defer profileCacheLock.Unlock()
+	profileCache = newProfileCache()
+	profileCache.MaxEntries = size
+	profileGeneration = 0
+	profileEvictedGeneration = 0
+}
+
+func TestProfileCacheHitAndTTL(t *testing.T) {
+	resetProfileCache(t, profileCacheSize)
+
+	_, missing, generation := cachedUserInfos([]string{"u1", "u2", "u1"})
+	if len(missing) != 2 {
+		t.Fatalf("missing %v, want u1 and u2 once", missing)
+	}
+	storeUserInfos(map[string]UserInfo{
+		"u1": {Id: "u1", Nickname: "fresh"},
+		"u2": {Id: "u2", Nickname: "stale"},
+	}, generation, time.Now())
+
+	// Expire u2 by moving its entry back in time.
+	profileCacheLock.Lock()
+	profileCache.Add("u2", cachedProfile{info: UserInfo{Id: "u2"}, cachedAt: time.Now().Add(-profileCacheTTL - time.Second)})
+	profileCacheLock.Unlock()
+
+	infos, missing, _ := cachedUserInfos([]string{"u1", "u2"})
+	if infos["u1"].Nickname != "fresh" {
+		t.Errorf("u1 = %+v, want the cached profile", infos["u1"])
+	}
+	if _, ok := infos["u2"]; ok || len(missing) != 1 || missing[0] != "u2" {
+		t.Errorf("expired u2 served from cache: infos %v, missing %v", infos, missing)
+	}
+}
+
+func TestProfileCacheInvalidation(t *testing.T) {
+	resetProfileCache(t, profileCacheSize)
+
+	_, _, generation := cachedUserInfos([]string{"u1"})
+	storeUserInfos(map[string]UserInfo{"u1": {Id: "u1", Nickname: "old"}}, generation, time.Now())
+
+	invalidateUserInfo("u1")
+	if infos, missing, _ := cachedUserInfos([]string{"u1"}); len(infos) != 0 || len(missing) != 1 {
+		t.Errorf("invalidated profile served from cache: %v", infos)
+	}
+}
+
+func TestProfileCacheSkipsReadsOlderThanInvalidation(t *testing.T) {
+	resetProfileCache(t, profileCacheSize)
+
+	// A read starts, the profile is changed and invalidated, and the read
+	// then finishes with the old profile.
+	_, _, before := cachedUserInfos([]string{"u1", "u2"})
+	invalidateUserInfo("u1")
+	storeUserInfos(map[string]UserInfo{
+		"u1": {Id: "u1", Nickname: "old"},
+		"u2": {Id: "u2", Nickname: "unchanged"},
+	}, before, time.Now())
+
+	infos, missing, after := cachedUserInfos([]string{"u1", "u2"})
+	if _, ok := infos["u1"]; ok {
+		t.Errorf("profile read before the invalidation was cached: %+v", infos["u1"])
+	}
+	if infos["u2"].Nickname != "unchanged" {
+		t.Errorf("u2 = %+v, want the cached profile", infos["u2"])
+	}
+
+	// A read started after the invalidation is cached.
+	storeUserInfos(map[string]UserInfo{"u1": {Id: "u1", Nickname: "new"}}, after, time.Now())
+	if infos, _, _ := cachedUserInfos(missing); infos["u1"].Nickname != "new" {
+		t.Errorf("u1 = %+v, want the new profile", infos["u1"])
+	}
+}
+
+func TestProfileCacheEvictedTombstone(t *testing.T) {
+	resetProfileCache(t, 2)
+
+	_, _, before := cachedUserInfos([]string{"u1"})
+	invalidateUserInfo("u1")
+	// Push the tombstone out of the cache.
+	invalidateUserInfo("u2")
+	invalidateUserInfo("u3")
+	profileCacheLock.Lock()
+	_, present := profileCache.Get("u1")
+	profileCacheLock.Unlock()
+	if present {
+		t.Fatal("tombstone of u1 was not evicted")
+	}
+
+	storeUserInfos(map[string]UserInfo{"u1": {Id: "u1", Nickname: "old"}}, before, time.Now())
+	if infos, _, _ := cachedUserInfos([]string{"u1"}); len(infos) != 0 {
+		t.Errorf("read older than an evicted tombstone was cached: %v", infos)
+	}
+}
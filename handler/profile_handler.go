@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/gobloc/pagination"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Relationship describes how the viewer and the profile's user are related.
+// All flags are false when there is no viewer or the viewer is the user.
+type Relationship struct {
+	ViewerFollows bool `json:"viewer_follows"`
+	FollowsViewer bool `json:"follows_viewer"`
+	ViewerBlocked bool `json:"viewer_blocked"`
+}
+
+// Profile is a user's public page. When the viewer blocked the user or the
+// user is banned, Hidden is set and only the user's id, nickname and images
+// are shown. A user who blocked the viewer is reported as not found, so
+// the viewer cannot tell they were blocked.
+type Profile struct {
+	UserInfo     UserInfo               `json:"user_info"`
+	Stats        UserStats              `json:"stats"`
+	Relationship Relationship           `json:"relationship"`
+	Hidden       bool                   `json:"hidden"`
+	Banned       bool                   `json:"banned"`
+	Videos       pagination.Page[Video] `json:"videos"`
+}
+
+// GetProfile returns the user's profile as seen by viewer_id, with the first
+// page of their videos. Later pages are read from /user_videos with the
+// returned cursor.
+func GetProfile(c *gin.Context) {
+	userId := c.Param("id")
+	viewerId := c.DefaultQuery("viewer_id", "")
+
+	scope := "user_videos:" + userId
+	req, ok := parsePageRequest(c, scope, videoPageLimits)
+	if !ok {
+		return
+	}
+
+	user, err := dbClient.Collection("users").Doc(userId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	relationship, blockedViewer, err := getRelationship(viewerId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if blockedViewer {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+		return
+	}
+
+	banned := isUserBanned(user)
+	if relationship.ViewerBlocked || banned {
+		info := userInfoFromDoc(user)
+		info.Intro = ""
+		c.JSON(http.StatusOK, Profile{
+			UserInfo:     info,
+			Relationship: Relationship{ViewerBlocked: relationship.ViewerBlocked},
+			Hidden:       true,
+			Banned:       banned,
+			Videos:       pagination.NewPage([]Video{}, ""),
+		})
+		return
+	}
+
+	stats, err := getUserStats([]string{userId})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := Profile{
+		UserInfo:     userInfoFromDoc(user),
+		Stats:        stats[userId],
+		Relationship: relationship,
+	}
+
+	videos, nextCursor, err := getUserVideosFromDatabase(userId, scope, req)
+	if err != nil {
+		respondPageError(c, err, fmt.Sprintf("Failed to fetch user videos: %v", err))
+		return
+	}
+	if err := attachVideoStats(videos, viewerId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to fetch video stats: %v", err),
+		})
+		return
+	}
+	profile.Videos = pagination.NewPage(videos, nextCursor)
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// getRelationship returns the relationship of the viewer to the user, and
+// whether the user blocked the viewer, which is kept out of it.
+func getRelationship(viewerId string, userId string) (Relationship, bool, error) {
+	var relationship Relationship
+	if viewerId == "" || viewerId == userId {
+		return relationship, false, nil
+	}
+
+	var err error
+	if relationship.ViewerFollows, err = isUserFollowing(ctx, viewerId, userId); err != nil {
+		return Relationship{}, false, err
+	}
+	if relationship.FollowsViewer, err = isUserFollowing(ctx, userId, viewerId); err != nil {
+		return Relationship{}, false, err
+	}
+	if relationship.ViewerBlocked, err = hasBlocked(viewerId, userId); err != nil {
+		return Relationship{}, false, err
+	}
+	blockedViewer, err := hasBlocked(userId, viewerId)
+	if err != nil {
+		return Relationship{}, false, err
+	}
+	return relationship, blockedViewer, nil
+}
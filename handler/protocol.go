@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Chat protocol versions. See PROTOCOL.md for the event schema of each.
+//
+// Version 1 is the original protocol: fire-and-forget client events and no
+// feedback on failures. Version 2 adds the hello handshake, request ids,
+// ack events for message sends and error events.
+const (
+	protocolV1            = 1
+	protocolV2            = 2
+	latestProtocolVersion = protocolV2
+)
+
+// Websocket subprotocols understood by /ws, in server preference order. The
+// unversioned names predate protocol versioning and select version 1. Clients
+// that offer no subprotocol get version 1 over JSON.
+var chatSubprotocols = []string{
+	"dtalk.v2.proto",
+	"dtalk.v2.json",
+	"dtalk.proto",
+	"dtalk.json",
+}
+
+// Error codes carried by error events.
+const (
+	errorBadRequest        = "bad_request"
+	errorUnknownEvent      = "unknown_event"
+	errorInvalidAttachment = "invalid_attachment"
+	errorSaveFailed        = "save_failed"
+	errorLikeFailed        = "like_failed"
+	errorReadFailed        = "read_failed"
+	errorChatDisabled      = "chat_disabled"
+	errorFollowersOnly     = "followers_only"
+	errorSlowMode          = "slow_mode"
+	errorForbidden         = "forbidden"
+	errorPinFailed         = "pin_failed"
+)
+
+type EventError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// negotiateSubprotocol returns the protocol version and framing selected by
+// a websocket subprotocol name.
+func negotiateSubprotocol(subprotocol string) (int, eventCodec) {
+	version := protocolV1
+	if strings.HasPrefix(subprotocol, "dtalk.v2.") {
+		version = protocolV2
+	}
+
+	if strings.HasSuffix(subprotocol, ".proto") {
+		return version, protoCodec{}
+	}
+	return version, jsonCodec{}
+}
+
+// queryProtocolVersion reads the protocol_version query parameter used by the
+// SSE and long-polling transports, defaulting to version 1.
+func queryProtocolVersion(r *http.Request) int {
+	version, err := strconv.Atoi(r.URL.Query().Get("protocol_version"))
+	if err != nil || version < protocolV1 {
+		return protocolV1
+	}
+	if version > latestProtocolVersion {
+		return latestProtocolVersion
+	}
+	return version
+}
+
+func helloEvent(version int) Event {
+	return Event{
+		EventType:       "hello",
+		ProtocolVersion: &version,
+	}
+}
+
+// errorEvent builds the error reply to a client event, echoing its request id.
+func errorEvent(request Event, code string, message string) *Event {
+	return &Event{
+		EventType: "error",
+		RequestId: request.RequestId,
+		Error: &EventError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// errorStatus maps error codes to the HTTP status of POST /chat/events.
+func errorStatus(code string) int {
+	switch code {
+	case errorBadRequest, errorUnknownEvent, errorInvalidAttachment:
+		return http.StatusBadRequest
+	case errorChatDisabled, errorFollowersOnly, errorForbidden:
+		return http.StatusForbidden
+	case errorSlowMode:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type UnreadCount struct {
+	RoomId       string `json:"room_id"`
+	UnreadCount  int    `json:"unread_count"`
+	LastReadTime string `json:"last_read_time"`
+}
+
+// formatSendTime formats a message send time for clients. Read times are
+// compared against send times, so both keep every stored digit.
+func formatSendTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseReadTime parses a read_time sent by a client. Times in the future are
+// clamped to now so a cursor cannot skip messages not sent yet.
+func parseReadTime(readTime string, now time.Time) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, readTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if parsed.After(now) {
+		return now, nil
+	}
+	return parsed, nil
+}
+
+// readCursorRef returns the read cursor document of a user in a room.
+// Cursors are keyed by user and room so that a user has at most one per room.
+func readCursorRef(userId string, roomId string) *firestore.DocumentRef {
+	return dbClient.Collection("read_cursors").Doc(userId + "_" + roomId)
+}
+
+// ensureReadCursor creates an empty cursor the first time a user joins a room,
+// so the room shows up in the user's conversations with everything unread.
+func ensureReadCursor(userId string, roomId string) error {
+	if userId == "" {
+		return nil
+	}
+
+	_, err := readCursorRef(userId, roomId).Create(ctx, map[string]interface{}{
+		"userId":       userId,
+		"roomId":       roomId,
+		"lastReadTime": time.Time{},
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	return nil
+}
+
+// updateReadCursor moves the user's cursor in the room forward to readTime.
+// Cursors never move backwards; the effective read time is returned.
+func updateReadCursor(userId string, roomId string, readTime time.Time) (time.Time, error) {
+	cursorRef := readCursorRef(userId, roomId)
+	effective := readTime
+
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		effective = readTime
+
+		cursorDoc, err := tx.Get(cursorRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if cursorDoc.Exists() {
+			if last, ok := cursorDoc.Data()["lastReadTime"].(time.Time); ok && !last.Before(readTime) {
+				effective = last
+				return nil
+			}
+		}
+
+		return tx.Set(cursorRef, map[string]interface{}{
+			"userId":       userId,
+			"roomId":       roomId,
+			"lastReadTime": readTime,
+		})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return effective, nil
+}
+
+// handleReadEvent records that the connected user has read the room up to the
+// event's read_time (or now when omitted) and returns the read_receipt event
+// to broadcast to the other participants.
+func handleReadEvent(user *User, event Event) (*Event, error) {
+	if user.UserId == "" {
+		return nil, fmt.Errorf("read event without user_id")
+	}
+
+	readTime := time.Now()
+	if event.ReadTime != nil {
+		parsed, err := parseReadTime(*event.ReadTime, readTime)
+		if err != nil {
+			return nil, err
+		}
+		readTime = parsed
+	}
+
+	effective, err := updateReadCursor(user.UserId, user.RoomId, readTime)
+	if err != nil {
+		return nil, err
+	}
+
+	readTimeStr := formatSendTime(effective)
+	userId := user.UserId
+	return &Event{
+		EventType: "read_receipt",
+		UserId:    &userId,
+		ReadTime:  &readTimeStr,
+		sender:    user,
+	}, nil
+}
+
+// countUnreadMessages counts messages in the room sent by other users after
+// the given time. Firestore cannot count with a != filter next to the range on
+// sendTime, so the user's own messages are counted separately and subtracted.
+func countUnreadMessages(userId string, roomId string, since time.Time) (int, error) {
+	query := dbClient.Collection("chat").Where("roomId", "==", roomId).Where("sendTime", ">", since)
+	all, err := countQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	own, err := countQuery(query.Where("username", "==", userId))
+	if err != nil {
+		return 0, err
+	}
+	return all - own, nil
+}
+
+// countQuery counts the documents matching query without reading them.
+func countQuery(query firestore.Query) (int, error) {
+	result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := result["count"].(interface{ GetIntegerValue() int64 })
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", result["count"])
+	}
+	return int(count.GetIntegerValue()), nil
+}
+
+func GetUnreadCounts(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+	roomId := c.DefaultQuery("room_id", "")
+
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is missing"})
+		return
+	}
+
+	var cursorDocs []*firestore.DocumentSnapshot
+	if roomId != "" {
+		cursorDoc, err := readCursorRef(userId, roomId).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cursorDocs = append(cursorDocs, cursorDoc)
+	} else {
+		docs, err := dbClient.Collection("read_cursors").Where("userId", "==", userId).Documents(ctx).GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cursorDocs = docs
+	}
+
+	// Rooms are counted concurrently; each count is an aggregation, so no
+	// message is read.
+	counts := make([]UnreadCount, len(cursorDocs))
+	errs := make([]error, len(cursorDocs))
+	var wg sync.WaitGroup
+	for i, cursorDoc := range cursorDocs {
+		lastReadTime := time.Time{}
+		room := roomId
+		if cursorDoc.Exists() {
+			lastReadTime, _ = cursorDoc.Data()["lastReadTime"].(time.Time)
+			room = cursorDoc.Data()["roomId"].(string)
+		}
+
+		wg.Add(1)
+		go func(i int, room string, lastReadTime time.Time) {
+			defer wg.Done()
+			unread, err := countUnreadMessages(userId, room, lastReadTime)
+			counts[i] = UnreadCount{
+				RoomId:       room,
+				UnreadCount:  unread,
+				LastReadTime: formatSendTime(lastReadTime),
+			}
+			errs[i] = err
+		}(i, room, lastReadTime)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range counts {
+		if errs[i] != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errs[i].Error()})
+			return
+		}
+		total += counts[i].UnreadCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rooms": counts, "total": total})
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendTimeRoundTrip(t *testing.T) {
+	// Two messages sent within the same second must stay apart once a client
+	// echoes the first one's send time back as its read time.
+	first := time.Date(2026, 10, 19, 10, 0, 0, 123456000, time.UTC)
+	second := first.Add(time.Microsecond)
+
+	readTime, err := parseReadTime(formatSendTime(first), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !readTime.Equal(first) {
+		t.Errorf("read time %v, want %v", readTime, first)
+	}
+	if !second.After(readTime) {
+		t.Errorf("message sent at %v counts as read at %v", second, readTime)
+	}
+}
+
+func TestParseReadTime(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	readTime, err := parseReadTime("2026-10-19T09:00:00Z", now)
+	if err != nil || !readTime.Equal(now.Add(-time.Hour)) {
+		t.Errorf("second precision: %v, %v", readTime, err)
+	}
+
+	readTime, err = parseReadTime("2026-10-19T11:00:00.5Z", now)
+	if err != nil || !readTime.Equal(now) {
+		t.Errorf("future read time %v, want clamped to %v (%v)", readTime, now, err)
+	}
+
+	if _, err := parseReadTime("yesterday", now); err == nil {
+		t.Error("invalid read time was accepted")
+	}
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	maxSlowModeSeconds = 3600
+
+	// roomStateTTL is how long a room's settings are used before they are
+	// read again, so changes made through another instance take effect.
+	roomStateTTL = 10 * time.Second
+)
+
+var (
+	// slowModeSent is when each user last sent a message to each room.
+	// Entries older than the longest slow mode are evicted by
+	// evictSlowModeLocked.
+	slowModeSent      = make(map[slowModeKey]time.Time)
+	slowModeLock      = sync.Mutex{}
+	slowModeLastEvict = time.Now()
+	slowModeEvictTick = time.Minute
+)
+
+type slowModeKey struct {
+	roomId string
+	userId string
+}
+
+// RoomSettings are the chat controls of a video's room. They are owned by the
+// video's uploader and stored on the video document. Pinned messages are kept
+// apart, in pinned_messages.
+type RoomSettings struct {
+	SlowModeSeconds int  `json:"slow_mode_seconds"`
+	FollowersOnly   bool `json:"followers_only"`
+	ChatDisabled    bool `json:"chat_disabled"`
+}
+
+// roomState is the cached per-room data used to enforce the settings in the
+// message path. Rooms without a video document have no uploader and the
+// default settings.
+type roomState struct {
+	uploader string
+	settings RoomSettings
+	loadedAt time.Time
+}
+
+func roomSettingsFromFirestore(value interface{}) RoomSettings {
+	var settings RoomSettings
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return settings
+	}
+
+	slowMode, _ := data["slow_mode_seconds"].(int64)
+	settings.SlowModeSeconds = int(slowMode)
+	settings.FollowersOnly, _ = data["followers_only"].(bool)
+	settings.ChatDisabled, _ = data["chat_disabled"].(bool)
+	return settings
+}
+
+func roomSettingsToFirestore(settings RoomSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"slow_mode_seconds": settings.SlowModeSeconds,
+		"followers_only":    settings.FollowersOnly,
+		"chat_disabled":     settings.ChatDisabled,
+	}
+}
+
+// loadRoomState returns the room's uploader and settings, reading them from
+// the video document when they were not read in the last roomStateTTL.
+func (room *Room) loadRoomState(roomId string) (*roomState, error) {
+	room.stateLock.Lock()
+	defer room.stateLock.Unlock()
+
+	if room.state != nil && time.Since(room.state.loadedAt) < roomStateTTL {
+		return room.state, nil
+	}
+
+	state := &roomState{loadedAt: time.Now()}
+	doc, err := dbClient.Collection("videos").Doc(roomId).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if err == nil {
+		state.uploader, _ = doc.Data()["uploader"].(string)
+		state.settings = roomSettingsFromFirestore(doc.Data()["room_settings"])
+	}
+
+	room.state = state
+	return room.state, nil
+}
+
+// checkRoomSettings returns the error event answering a message that the
+// room's settings do not allow, or nil. The uploader is never restricted.
+// When the message is allowed, the returned func must be called if saving it
+// fails, so that the failed message does not start the slow mode cooldown.
+func checkRoomSettings(room *Room, user *User, event Event) (*Event, func()) {
+	noop := func() {}
+
+	state, err := room.loadRoomState(user.RoomId)
+	if err != nil {
+		return errorEvent(event, errorSaveFailed, "failed to load room settings"), noop
+	}
+	if state.uploader != "" && user.UserId == state.uploader {
+		return nil, noop
+	}
+
+	room.stateLock.Lock()
+	settings := state.settings
+	room.stateLock.Unlock()
+
+	if settings.ChatDisabled {
+		return errorEvent(event, errorChatDisabled, "chat is disabled in this room"), noop
+	}
+
+	if settings.FollowersOnly {
+		following, err := isUserFollowing(ctx, user.UserId, state.uploader)
+		if err != nil {
+			return errorEvent(event, errorSaveFailed, "failed to check follow status"), noop
+		}
+		if !following {
+			return errorEvent(event, errorFollowersOnly, "only followers can chat in this room"), noop
+		}
+	}
+
+	if settings.SlowModeSeconds > 0 {
+		wait, cancel := reserveSlowMode(user.RoomId, user.UserId, time.Duration(settings.SlowModeSeconds)*time.Second)
+		if wait > 0 {
+			return errorEvent(event, errorSlowMode, fmt.Sprintf("slow mode: wait %d seconds", int(wait.Seconds()+0.999))), noop
+		}
+		return nil, cancel
+	}
+	return nil, noop
+}
+
+// reserveSlowMode returns how long the user must still wait before sending
+// to the room. When they need not wait, the message is recorded as sent now,
+// so that concurrent connections of the same user cannot both get through,
+// and the returned func takes the record back if the message is not saved.
+func reserveSlowMode(roomId string, userId string, interval time.Duration) (time.Duration, func()) {
+	slowModeLock.Lock()
+	defer slowModeLock.Unlock()
+
+	evictSlowModeLocked()
+
+	key := slowModeKey{roomId: roomId, userId: userId}
+	previous, hadPrevious := slowModeSent[key]
+	if wait := interval - time.Since(previous); hadPrevious && wait > 0 {
+		return wait, nil
+	}
+
+	sent := time.Now()
+	slowModeSent[key] = sent
+	return 0, func() {
+		slowModeLock.Lock()
+		defer slowModeLock.Unlock()
+
+		if !slowModeSent[key].Equal(sent) {
+			return
+		}
+		if hadPrevious {
+			slowModeSent[key] = previous
+		} else {
+			delete(slowModeSent, key)
+		}
+	}
+}
+
+// evictSlowModeLocked drops the records too old to restrict anyone, at most
+// once per slowModeEvictTick. The caller must hold slowModeLock.
+func evictSlowModeLocked() {
+	if time.Since(slowModeLastEvict) < slowModeEvictTick {
+		return
+	}
+	slowModeLastEvict = time.Now()
+
+	maxInterval := time.Duration(maxSlowModeSeconds) * time.Second
+	for key, sent := range slowModeSent {
+		if time.Since(sent) >= maxInterval {
+			delete(slowModeSent, key)
+		}
+	}
+}
+
+func GetRoomSettings(c *gin.Context) {
+	roomId := c.DefaultQuery("room_id", "")
+
+	doc, err := dbClient.Collection("videos").Doc(roomId).Get(ctx)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roomSettingsFromFirestore(doc.Data()["room_settings"]))
+}
+
+func UpdateRoomSettings(c *gin.Context) {
+	roomId := c.DefaultQuery("room_id", "")
+	userId := c.DefaultQuery("user_id", "")
+
+	var settings RoomSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if settings.SlowModeSeconds < 0 || settings.SlowModeSeconds > maxSlowModeSeconds {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("slow_mode_seconds must be between 0 and %d", maxSlowModeSeconds)})
+		return
+	}
+
+	videoRef := dbClient.Collection("videos").Doc(roomId)
+	doc, err := videoRef.Get(ctx)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if doc.Data()["uploader"].(string) != userId {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the uploader can change room settings"})
+		return
+	}
+
+	_, err = videoRef.Set(ctx, map[string]interface{}{
+		"room_settings": roomSettingsToFirestore(settings),
+	}, firestore.MergeAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Rooms nobody is in load the settings when they are next joined; rooms
+	// open on other instances read them again within roomStateTTL.
+	if room := lookupRoom(roomId); room != nil {
+		room.stateLock.Lock()
+		if room.state != nil {
+			room.state.settings = settings
+		}
+		room.stateLock.Unlock()
+
+		room.broadcast(Event{
+			EventType:    "room_settings",
+			RoomSettings: &settings,
+		})
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
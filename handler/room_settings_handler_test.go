@@ -0,0 +1,25 @@
+package handler
+
+import "testing"
+
+func TestRoomSettingsFromFirestore(t *testing.T) {
+	if got := roomSettingsFromFirestore(nil); got != (RoomSettings{}) {
+		t.Errorf("missing settings read as %+v, want the defaults", got)
+	}
+
+	// Firestore returns integers as int64, and videos from before pinned
+	// messages moved out of the settings may still carry pinned_message.
+	stored := map[string]interface{}{
+		"slow_mode_seconds": int64(30),
+		"followers_only":    true,
+		"chat_disabled":     false,
+		"pinned_message":    "welcome",
+	}
+	want := RoomSettings{SlowModeSeconds: 30, FollowersOnly: true}
+	if got := roomSettingsFromFirestore(stored); got != want {
+		t.Errorf("settings read as %+v, want %+v", got, want)
+	}
+	if _, ok := roomSettingsToFirestore(want)["pinned_message"]; ok {
+		t.Error("pinned_message is still written")
+	}
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// searchIndex holds the users and videos. They are indexed as they are
+	// created and changed; the search_index migration indexes the ones from
+	// before.
+	searchIndex SearchIndex = firestoreSearchIndex{}
+
+	searchResultLimits = pagination.DefaultLimits
+)
+
+// SearchResult is a user or a video found by search; Type tells which.
+type SearchResult struct {
+	Type  string    `json:"type"`
+	User  *UserInfo `json:"user,omitempty"`
+	Video *Video    `json:"video,omitempty"`
+	Score float64   `json:"score"`
+}
+
+// SearchPage is a page of search results, with the number of matches.
+// When Capped is set more users or videos matched than were ranked, and Total
+// counts only those.
+type SearchPage struct {
+	pagination.Page[SearchResult]
+	Total  int  `json:"total"`
+	Capped bool `json:"capped"`
+}
+
+func userSearchDocument(userId string, nickname string, intro string, followerCount int64) SearchDocument {
+	return SearchDocument{
+		Kind:  searchKindUser,
+		Id:    userId,
+		Owner: userId,
+		Fields: []SearchField{
+			{Text: nickname, Weight: 3},
+			{Text: intro, Weight: 1},
+		},
+		Popularity: float64(followerCount),
+	}
+}
+
+func videoSearchDocument(videoId string, uploader string, title string, description string, tags []string, likeCount int64) SearchDocument {
+	fields := []SearchField{
+		{Text: title, Weight: 3},
+		{Text: description, Weight: 1},
+	}
+	for _, tag := range tags {
+		fields = append(fields, SearchField{Text: tag, Weight: 2})
+	}
+	return SearchDocument{
+		Kind:       searchKindVideo,
+		Id:         videoId,
+		Owner:      uploader,
+		Fields:     fields,
+		Popularity: float64(likeCount),
+	}
+}
+
+func userSearchDocumentFromDoc(doc *firestore.DocumentSnapshot, followerCount int64) SearchDocument {
+	nickname, _ := doc.Data()["nickname"].(string)
+	intro, _ := doc.Data()["introduction"].(string)
+	return userSearchDocument(doc.Ref.ID, nickname, intro, followerCount)
+}
+
+func videoSearchDocumentFromDoc(doc *firestore.DocumentSnapshot) SearchDocument {
+	title, _ := doc.Data()["title"].(string)
+	description, _ := doc.Data()["description"].(string)
+	uploader, _ := doc.Data()["uploader"].(string)
+	likeCount, _ := doc.Data()["like_count"].(int64)
+	return videoSearchDocument(doc.Ref.ID, uploader, title, description, stringsFromFirestore(doc.Data()["tags"]), likeCount)
+}
+
+// migrateSearchIndex indexes every user and video.
+func migrateSearchIndex() error {
+	users, err := dbClient.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	userIds := make([]string, 0, len(users))
+	for _, user := range users {
+		userIds = append(userIds, user.Ref.ID)
+	}
+	stats, err := getUserStats(userIds)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, user := range users {
+		if err := searchIndex.Index(userSearchDocumentFromDoc(user, stats[user.Ref.ID].FollowerCount)); err != nil {
+			log.Printf("failed to index user %s: %v", user.Ref.ID, err)
+			failed++
+		}
+	}
+
+	videos, err := dbClient.Collection("videos").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, video := range videos {
+		if err := searchIndex.Index(videoSearchDocumentFromDoc(video)); err != nil {
+			log.Printf("failed to index video %s: %v", video.Ref.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d users and videos were not indexed", failed)
+	}
+	return nil
+}
+
+// reindexUser refreshes the user's search entry after their profile changed.
+func reindexUser(userId string) {
+	user, err := dbClient.Collection("users").Doc(userId).Get(ctx)
+	if err != nil {
+		log.Printf("failed to reindex user %s: %v", userId, err)
+		return
+	}
+	stats, err := getUserStats([]string{userId})
+	if err != nil {
+		log.Printf("failed to reindex user %s: %v", userId, err)
+		return
+	}
+	if err := searchIndex.Index(userSearchDocumentFromDoc(user, stats[userId].FollowerCount)); err != nil {
+		log.Printf("failed to reindex user %s: %v", userId, err)
+	}
+}
+
+// Search finds users by nickname and introduction and videos by title,
+// description and tags. type narrows the results to "users" or "videos".
+func Search(c *gin.Context) {
+	query := c.DefaultQuery("q", "")
+	searchType := c.DefaultQuery("type", "all")
+	userId := c.DefaultQuery("user_id", "")
+
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is missing"})
+		return
+	}
+
+	var kinds []string
+	switch searchType {
+	case "all":
+		kinds = []string{searchKindUser, searchKindVideo}
+	case "users":
+		kinds = []string{searchKindUser}
+	case "videos":
+		kinds = []string{searchKindVideo}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+
+	scope := "search:" + searchType + ":" + userId + ":" + query
+	req, ok := parsePageRequest(c, scope, searchResultLimits)
+	if !ok {
+		return
+	}
+
+	blockedIds, err := getBlockedIds(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hits, total, capped, err := searchIndex.Search(query, kinds, blockedIds, req.Offset, req.Size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := searchResults(hits, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	nextCursor := ""
+	if req.Offset+len(hits) < total {
+		nextCursor = pagination.AtOffset(scope, req.Offset+len(hits))
+	}
+
+	c.JSON(http.StatusOK, SearchPage{
+		Page:   pagination.NewPage(results, nextCursor),
+		Total:  total,
+		Capped: capped,
+	})
+}
+
+// searchResults loads the users and videos of the hits in batches, keeping
+// the hits' order. Hits deleted since they were indexed are left out.
+func searchResults(hits []SearchHit, viewerId string) ([]SearchResult, error) {
+	var userIds []string
+	var videoIds []string
+	for _, hit := range hits {
+		switch hit.Document.Kind {
+		case searchKindUser:
+			userIds = append(userIds, hit.Document.Id)
+		case searchKindVideo:
+			videoIds = append(videoIds, hit.Document.Id)
+		}
+	}
+
+	users, err := getUserInfos(userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := loadVideosByIds(videoIds)
+	if err != nil {
+		return nil, err
+	}
+	videos := make([]Video, 0, len(videoIds))
+	for _, id := range videoIds {
+		if video, ok := found[id]; ok {
+			videos = append(videos, video)
+		}
+	}
+	if err := attachVideoStats(videos, viewerId); err != nil {
+		return nil, err
+	}
+	videosById := make(map[string]Video, len(videos))
+	for _, video := range videos {
+		videosById[video.Id] = video
+	}
+
+	results := []SearchResult{}
+	for _, hit := range hits {
+		switch hit.Document.Kind {
+		case searchKindUser:
+			if user, ok := users[hit.Document.Id]; ok {
+				results = append(results, SearchResult{Type: searchKindUser, User: &user, Score: hit.Score})
+			}
+		case searchKindVideo:
+			if video, ok := videosById[hit.Document.Id]; ok {
+				results = append(results, SearchResult{Type: searchKindVideo, Video: &video, Score: hit.Score})
+			}
+		}
+	}
+	return results, nil
+}
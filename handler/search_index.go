@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SearchIndex is the index behind user and video search. The Firestore
+// implementation below serves production; a hosted search service can
+// replace it.
+type SearchIndex interface {
+	// Index adds doc, replacing any previous entry with its kind and id.
+	Index(doc SearchDocument) error
+	// Remove drops the entry with the kind and id, if any.
+	Remove(kind string, id string) error
+	// AddPopularity adds delta to the popularity of the entry with the kind
+	// and id, if any.
+	AddPopularity(kind string, id string, delta float64) error
+	// Search returns the documents of the given kinds matching every term of
+	// query as a word prefix, best first, skipping documents whose id or
+	// owner is in exclude. It skips offset hits, returns at most limit and
+	// returns the total number of hits alongside; capped reports that not
+	// every candidate was ranked, so the total may be short.
+	Search(query string, kinds []string, exclude map[string]bool, offset int, limit int) (hits []SearchHit, total int, capped bool, err error)
+}
+
+const (
+	searchKindUser  = "user"
+	searchKindVideo = "video"
+)
+
+var (
+	// Words are indexed by their prefixes up to maxSearchPrefixLength runes,
+	// and at most maxSearchPrefixes prefixes are kept per document, taken
+	// from its fields in order.
+	maxSearchPrefixLength = 15
+	maxSearchPrefixes     = 1000
+
+	// searchCandidateLimit is how many documents matching the most selective
+	// query term the Firestore index ranks, most popular first. Totals are
+	// capped by it, and search results report when they are.
+	searchCandidateLimit = 500
+)
+
+// SearchDocument is a user or video as the search index sees it. Owner is the
+// user the document belongs to: the user itself, or a video's uploader.
+// Popularity slightly favours documents that match equally well.
+type SearchDocument struct {
+	Kind       string
+	Id         string
+	Owner      string
+	Fields     []SearchField
+	Popularity float64
+}
+
+// SearchField is a searchable text with the weight of matches in it.
+type SearchField struct {
+	Text   string
+	Weight float64
+}
+
+type SearchHit struct {
+	Document SearchDocument
+	Score    float64
+}
+
+func searchKey(kind string, id string) string {
+	return kind + ":" + id
+}
+
+// matchSearchDocuments filters docs down to the hits of the terms, ranks them
+// and returns the requested page with the total number of hits.
+func matchSearchDocuments(docs []SearchDocument, terms []token, kinds []string, exclude map[string]bool, offset int, limit int) ([]SearchHit, int) {
+	wantKind := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		wantKind[kind] = true
+	}
+
+	var hits []SearchHit
+	for _, doc := range docs {
+		if !wantKind[doc.Kind] || exclude[doc.Id] || exclude[doc.Owner] {
+			continue
+		}
+		if score, ok := scoreSearchDocument(doc, terms); ok {
+			hits = append(hits, SearchHit{Document: doc, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return searchKey(hits[i].Document.Kind, hits[i].Document.Id) < searchKey(hits[j].Document.Kind, hits[j].Document.Id)
+	})
+
+	total := len(hits)
+	if offset >= total {
+		return nil, total
+	}
+	hits = hits[offset:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, total
+}
+
+// scoreSearchDocument scores each term by the best field it matches: a whole
+// word counts the field's full weight, a prefix counts in proportion to how
+// much of the word it covers. ok is false when a term matches no word.
+func scoreSearchDocument(doc SearchDocument, terms []token) (float64, bool) {
+	score := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, field := range doc.Fields {
+			for _, t := range tokenize(field.Text) {
+				if !strings.HasPrefix(t.text, term.text) {
+					continue
+				}
+				match := field.Weight * float64(len([]rune(term.text))) / float64(t.length)
+				if match > best {
+					best = match
+				}
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score + 0.1*math.Log1p(math.Max(0, doc.Popularity)), true
+}
+
+// searchPrefix cuts a word down to the longest prefix that is indexed.
+func searchPrefix(word string) string {
+	runes := []rune(word)
+	if len(runes) > maxSearchPrefixLength {
+		runes = runes[:maxSearchPrefixLength]
+	}
+	return string(runes)
+}
+
+// searchPrefixes returns the distinct indexed prefixes of the words of doc.
+func searchPrefixes(doc SearchDocument) []string {
+	texts := make([]string, 0, len(doc.Fields))
+	for _, field := range doc.Fields {
+		texts = append(texts, field.Text)
+	}
+	return wordPrefixes(texts...)
+}
+
+// wordPrefixes returns the distinct indexed prefixes of the words of texts,
+// at most maxSearchPrefixes of them, taken from the texts in order.
+func wordPrefixes(texts ...string) []string {
+	prefixes := []string{}
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, t := range tokenize(text) {
+			for _, prefix := range namePrefixes(searchPrefix(t.text)) {
+				if seen[prefix] {
+					continue
+				}
+				if len(prefixes) == maxSearchPrefixes {
+					return prefixes
+				}
+				seen[prefix] = true
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// selectiveTerm returns the term to look candidates up by: longer prefixes
+// match fewer words.
+func selectiveTerm(terms []token) string {
+	selective := terms[0].text
+	for _, term := range terms[1:] {
+		if len([]rune(term.text)) > len([]rune(selective)) {
+			selective = term.text
+		}
+	}
+	return searchPrefix(selective)
+}
+
+// firestoreSearchIndex keeps one search_index/{kind}_{id} document per entry
+// with the prefixes of its words, and finds candidates with an
+// array-contains query on the most selective term.
+type firestoreSearchIndex struct{}
+
+func searchEntryRef(kind string, id string) *firestore.DocumentRef {
+	return dbClient.Collection("search_index").Doc(kind + "_" + id)
+}
+
+func (firestoreSearchIndex) Index(doc SearchDocument) error {
+	_, err := searchEntryRef(doc.Kind, doc.Id).Set(ctx, searchEntryData(doc))
+	return err
+}
+
+func (firestoreSearchIndex) Remove(kind string, id string) error {
+	_, err := searchEntryRef(kind, id).Delete(ctx)
+	return err
+}
+
+func (firestoreSearchIndex) AddPopularity(kind string, id string, delta float64) error {
+	_, err := searchEntryRef(kind, id).Update(ctx, []firestore.Update{
+		{Path: "popularity", Value: firestore.Increment(delta)},
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (firestoreSearchIndex) Search(query string, kinds []string, exclude map[string]bool, offset int, limit int) ([]SearchHit, int, bool, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, 0, false, nil
+	}
+
+	q := dbClient.Collection("search_index").Where("prefixes", "array-contains", selectiveTerm(terms))
+	if len(kinds) == 1 {
+		q = q.Where("kind", "==", kinds[0])
+	}
+	snapshots, err := q.OrderBy("popularity", firestore.Desc).Limit(searchCandidateLimit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	docs := make([]SearchDocument, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		docs = append(docs, searchDocumentFromEntry(snapshot.Data()))
+	}
+	hits, total := matchSearchDocuments(docs, terms, kinds, exclude, offset, limit)
+	return hits, total, len(snapshots) == searchCandidateLimit, nil
+}
+
+// searchEntryData is the search_index document of doc.
+func searchEntryData(doc SearchDocument) map[string]interface{} {
+	fields := make([]interface{}, 0, len(doc.Fields))
+	for _, field := range doc.Fields {
+		fields = append(fields, map[string]interface{}{"text": field.Text, "weight": field.Weight})
+	}
+	return map[string]interface{}{
+		"kind":       doc.Kind,
+		"id":         doc.Id,
+		"owner":      doc.Owner,
+		"fields":     fields,
+		"popularity": doc.Popularity,
+		"prefixes":   searchPrefixes(doc),
+	}
+}
+
+// searchDocumentFromEntry reads doc back from the data of its search_index
+// document.
+func searchDocumentFromEntry(data map[string]interface{}) SearchDocument {
+	doc := SearchDocument{}
+	doc.Kind, _ = data["kind"].(string)
+	doc.Id, _ = data["id"].(string)
+	doc.Owner, _ = data["owner"].(string)
+	doc.Popularity, _ = data["popularity"].(float64)
+	if popularity, ok := data["popularity"].(int64); ok {
+		doc.Popularity = float64(popularity)
+	}
+
+	fields, _ := data["fields"].([]interface{})
+	for _, f := range fields {
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, _ := field["text"].(string)
+		weight, _ := field["weight"].(float64)
+		doc.Fields = append(doc.Fields, SearchField{Text: text, Weight: weight})
+	}
+	return doc
+}
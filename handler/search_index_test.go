@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func fixtureSearchDocuments() []SearchDocument {
+	return []SearchDocument{
+		userSearchDocument("u-cook", "cooking", "I cook every day", 10),
+		userSearchDocument("u-coo", "coolcat", "hello", 500),
+		userSearchDocument("u-blocked", "cookie", "blocked", 0),
+		videoSearchDocument("v-pasta", "u-coo", "Pasta cooking", "quick dinner", []string{"food"}, 3),
+		videoSearchDocument("v-cookie", "u-blocked", "Cookie recipe", "", []string{"baking"}, 90),
+		videoSearchDocument("v-cake", "u-cook", "Cake", "cooking a cake for a party", []string{"baking", "cook"}, 0),
+	}
+}
+
+func hitIds(hits []SearchHit) []string {
+	ids := []string{}
+	for _, hit := range hits {
+		ids = append(ids, hit.Document.Id)
+	}
+	return ids
+}
+
+func TestMatchSearchDocuments(t *testing.T) {
+	all := []string{searchKindUser, searchKindVideo}
+
+	queries := map[string][]string{
+		"coo":         {"u-coo", "u-cook", "u-blocked", "v-cookie", "v-cake", "v-pasta"},
+		"cook cake":   {"v-cake"},
+		"PASTA":       {"v-pasta"},
+		"ake":         {},
+		"cooking day": {"u-cook"},
+	}
+	for query, want := range queries {
+		hits, total := matchSearchDocuments(fixtureSearchDocuments(), tokenize(query), all, nil, 0, 20)
+		got := hitIds(hits)
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) || total != len(want) {
+			t.Errorf("%q matched %v (total %d), want %v", query, got, total, want)
+		}
+	}
+
+	hits, _ := matchSearchDocuments(fixtureSearchDocuments(), tokenize("coo"), []string{searchKindUser}, nil, 0, 20)
+	for _, hit := range hits {
+		if hit.Document.Kind != searchKindUser {
+			t.Errorf("users search returned %s %s", hit.Document.Kind, hit.Document.Id)
+		}
+	}
+}
+
+func TestMatchSearchDocumentsExcludes(t *testing.T) {
+	// Excluding a user drops the user and the videos they uploaded.
+	exclude := map[string]bool{"u-blocked": true}
+	hits, total := matchSearchDocuments(fixtureSearchDocuments(), tokenize("cook"), []string{searchKindUser, searchKindVideo}, exclude, 0, 20)
+	for _, id := range hitIds(hits) {
+		if id == "u-blocked" || id == "v-cookie" {
+			t.Errorf("excluded %s was returned", id)
+		}
+	}
+	if total != len(hits) {
+		t.Errorf("total %d counts excluded hits, %d returned", total, len(hits))
+	}
+}
+
+func TestMatchSearchDocumentsOffsetAndTotal(t *testing.T) {
+	docs := fixtureSearchDocuments()
+	terms := tokenize("coo")
+	all := []string{searchKindUser, searchKindVideo}
+
+	everything, total := matchSearchDocuments(docs, terms, all, nil, 0, 20)
+	if total != 6 {
+		t.Fatalf("total %d, want 6", total)
+	}
+
+	var paged []SearchHit
+	for offset := 0; offset < total; offset += 4 {
+		hits, pageTotal := matchSearchDocuments(docs, terms, all, nil, offset, 4)
+		if pageTotal != total {
+			t.Errorf("offset %d: total %d, want %d", offset, pageTotal, total)
+		}
+		paged = append(paged, hits...)
+	}
+	if !reflect.DeepEqual(hitIds(paged), hitIds(everything)) {
+		t.Errorf("pages %v, want %v", hitIds(paged), hitIds(everything))
+	}
+
+	hits, pageTotal := matchSearchDocuments(docs, terms, all, nil, 10, 4)
+	if len(hits) != 0 || pageTotal != total {
+		t.Errorf("past the end: %d hits, total %d", len(hits), pageTotal)
+	}
+}
+
+func TestScoreSearchDocument(t *testing.T) {
+	score := func(doc SearchDocument, query string) float64 {
+		t.Helper()
+		s, ok := scoreSearchDocument(doc, tokenize(query))
+		if !ok {
+			t.Fatalf("%q does not match %s", query, doc.Id)
+		}
+		return s
+	}
+
+	// A match in the title beats one in the description.
+	pasta := videoSearchDocument("v-pasta", "u1", "Pasta cooking", "", nil, 0)
+	cake := videoSearchDocument("v-cake", "u1", "Cake", "cooking a cake", nil, 0)
+	if score(pasta, "cooking") <= score(cake, "cooking") {
+		t.Errorf("description match scored at least as high as a title match")
+	}
+
+	// A whole word beats a prefix of a longer word, even when less popular.
+	book := videoSearchDocument("v-book", "u1", "Cookbook", "", nil, 50)
+	word := videoSearchDocument("v-word", "u1", "Cook", "", nil, 0)
+	if score(word, "cook") <= score(book, "cook") {
+		t.Errorf("prefix match scored at least as high as a whole word")
+	}
+
+	// Equal matches are ordered by popularity.
+	popular := userSearchDocument("u-popular", "cook", "", 100)
+	quiet := userSearchDocument("u-quiet", "cook", "", 1)
+	if score(popular, "cook") <= score(quiet, "cook") {
+		t.Errorf("popularity did not break the tie")
+	}
+
+	if _, ok := scoreSearchDocument(cake, tokenize("cake pasta")); ok {
+		t.Errorf("document matched a term it does not contain")
+	}
+}
+
+func TestSearchPrefixes(t *testing.T) {
+	doc := SearchDocument{Fields: []SearchField{{Text: "Go go gopher", Weight: 1}}}
+	if got, want := searchPrefixes(doc), []string{"g", "go", "gop", "goph", "gophe", "gopher"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searchPrefixes = %v, want %v", got, want)
+	}
+
+	long := SearchDocument{Fields: []SearchField{{Text: strings.Repeat("a", 40), Weight: 1}}}
+	if got := searchPrefixes(long); len(got) != maxSearchPrefixLength {
+		t.Errorf("%d prefixes of a long word, want %d", len(got), maxSearchPrefixLength)
+	}
+}
+
+func TestSelectiveTerm(t *testing.T) {
+	if got := selectiveTerm(tokenize("a video of cats")); got != "video" {
+		t.Errorf("selectiveTerm = %q, want video", got)
+	}
+	if got := selectiveTerm(tokenize("supercalifragilistic")); got != "supercalifragil" {
+		t.Errorf("selectiveTerm of a long word = %q, want its indexed prefix", got)
+	}
+}
+
+func TestSearchEntryRoundTrip(t *testing.T) {
+	for _, doc := range fixtureSearchDocuments() {
+		data := searchEntryData(doc)
+		if got := searchDocumentFromEntry(data); !reflect.DeepEqual(got, doc) {
+			t.Errorf("round trip of %s = %+v, want %+v", doc.Id, got, doc)
+		}
+		if prefixes := data["prefixes"].([]string); !reflect.DeepEqual(prefixes, searchPrefixes(doc)) {
+			t.Errorf("prefixes of %s = %v", doc.Id, prefixes)
+		}
+	}
+
+	// Increments of whole numbers leave an integer popularity behind.
+	data := searchEntryData(userSearchDocument("u1", "cook", "", 0))
+	data["popularity"] = int64(7)
+	if got := searchDocumentFromEntry(data).Popularity; got != 7 {
+		t.Errorf("integer popularity read as %v", got)
+	}
+}
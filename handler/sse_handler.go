@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	streamBufferSize  = 64
+	streamHeartbeat   = 25 * time.Second
+	longPollTimeout   = 25 * time.Second
+	longPollBatchSize = 100
+)
+
+// HandleEventStream streams the events of a room as Server-Sent Events for
+// clients whose proxies do not allow websocket upgrades. Events are sent with
+// the event type as SSE event name and the JSON encoded Event as data.
+func HandleEventStream(c *gin.Context) {
+	roomId := c.Query("room_id")
+	userId := c.Query("user_id")
+
+	if roomId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id is missing"})
+		return
+	}
+
+	version := queryProtocolVersion(c.Request)
+	user := &User{RoomId: roomId, UserId: userId, Events: make(chan Event, streamBufferSize), version: version}
+	joinRoom(roomId, user)
+	defer removeUserFromRoom(roomId, user)
+
+	if err := ensureReadCursor(userId, roomId); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	if version >= protocolV2 {
+		hello := helloEvent(version)
+		c.SSEvent(hello.EventType, hello)
+	}
+	for _, event := range initialEvents(roomId, userId) {
+		c.SSEvent(event.EventType, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-user.Events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.EventType, event)
+			return true
+		case <-heartbeat.C:
+			// Comment lines keep idle connections open through proxies.
+			_, err := w.Write([]byte(": ping\n\n"))
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// PostEvent accepts the events a websocket client would send (message, like,
+// read, pin and unpin) from SSE and long-polling clients and handles them through the
+// same room hub. The response body is the ack or error event answering it.
+func PostEvent(c *gin.Context) {
+	roomId := c.Query("room_id")
+	userId := c.Query("user_id")
+
+	if roomId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id is missing"})
+		return
+	}
+
+	var event Event
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, errorEvent(event, errorBadRequest, err.Error()))
+		return
+	}
+
+	room := detachedRoom(roomId)
+	user := &User{RoomId: roomId, UserId: userId, version: queryProtocolVersion(c.Request)}
+	reply := handleClientEvent(room, user, event)
+
+	if reply == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "Event accepted"})
+		return
+	}
+	if reply.Error != nil {
+		c.JSON(errorStatus(reply.Error.Code), reply)
+		return
+	}
+	c.JSON(http.StatusOK, reply)
+}
+
+// PollEvents is the long-polling fallback for clients that cannot keep an SSE
+// stream open. It returns message events only: likes, read receipts and the
+// other room events are not kept between polls, so polling clients read those
+// through the REST endpoints. Messages sent after `since` are returned
+// immediately; when there are none the request waits for the next message in
+// the room. next_since is the send time of the last returned message with
+// nanosecond precision, or the since of the request when none were returned.
+func PollEvents(c *gin.Context) {
+	roomId := c.Query("room_id")
+	userId := c.Query("user_id")
+
+	if roomId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id is missing"})
+		return
+	}
+
+	since := time.Now()
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since"})
+			return
+		}
+		since = parsed
+	}
+
+	// Join before reading missed messages so nothing is lost in between.
+	user := &User{RoomId: roomId, UserId: userId, Events: make(chan Event, streamBufferSize)}
+	joinRoom(roomId, user)
+	defer removeUserFromRoom(roomId, user)
+
+	events, next, err := loadMessagesSince(roomId, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	timeout := time.NewTimer(longPollTimeout)
+	defer timeout.Stop()
+
+	// Broadcast messages only signal that there is something to read: the
+	// messages are read back from Firestore for their stored send times.
+wait:
+	for len(events) == 0 {
+		select {
+		case event, ok := <-user.Events:
+			if !ok {
+				break wait
+			}
+			if event.EventType != "message" {
+				continue
+			}
+			events, next, err = loadMessagesSince(roomId, since)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		case <-timeout.C:
+			break wait
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":     events,
+		"next_since": next.Format(time.RFC3339Nano),
+	})
+}
+
+// loadMessagesSince returns message events for messages sent in the room
+// after the given time, oldest first, and the send time of the last one, or
+// since when there are none.
+func loadMessagesSince(roomId string, since time.Time) ([]Event, time.Time, error) {
+	docs, err := dbClient.Collection("chat").Where("roomId", "==", roomId).Where("sendTime", ">", since).OrderBy("sendTime", firestore.Asc).Limit(longPollBatchSize).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, since, err
+	}
+
+	events := make([]Event, 0, len(docs))
+	for _, doc := range docs {
+		msg := Message{
+			Id:          doc.Ref.ID,
+			UserImage:   doc.Data()["user_image"].(string),
+			UserId:      doc.Data()["username"].(string),
+			Nickname:    doc.Data()["nickname"].(string),
+			Text:        doc.Data()["text"].(string),
+			RoomId:      doc.Data()["roomId"].(string),
+			SendTime:    formatSendTime(doc.Data()["sendTime"].(time.Time)),
+			Mentions:    mentionsFromFirestore(doc.Data()["mentions"]),
+			Attachments: attachmentsFromFirestore(doc.Data()["attachments"]),
+		}
+		events = append(events, Event{EventType: "message", Message: &msg})
+	}
+	if len(docs) > 0 {
+		since = docs[len(docs)-1].Data()["sendTime"].(time.Time)
+	}
+	return events, since, nil
+}
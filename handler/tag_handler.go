@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+	maxTagsPerVideo   = 10
+	maxTagLength      = 50
+	autocompleteLimit = 10
+
+	tagPageLimits = pagination.Limits{Default: 10, Max: 30}
+)
+
+// Tag is a hashtag with the number of videos using it.
+type Tag struct {
+	Name       string `json:"name"`
+	VideoCount int64  `json:"video_count"`
+}
+
+// Tags are stored in tags/{name} with the number of videos using them and
+// every prefix of the name, which autocomplete queries. A tag is deleted when
+// its last video is.
+
+// isReservedTag reports whether the tag cannot be used as a document id:
+// Firestore reserves ids of the form __.*__.
+func isReservedTag(tag string) bool {
+	return len(tag) >= 4 && strings.HasPrefix(tag, "__") && strings.HasSuffix(tag, "__")
+}
+
+// parseHashtags returns the distinct hashtags in the texts, lowercased, in
+// order of first appearance. Tags Firestore cannot store are left out.
+func parseHashtags(texts ...string) []string {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+			tag := strings.ToLower(match[1])
+			if seen[tag] || len([]rune(tag)) > maxTagLength || isReservedTag(tag) {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+			if len(tags) == maxTagsPerVideo {
+				return tags
+			}
+		}
+	}
+	return tags
+}
+
+func tagRef(tag string) *firestore.DocumentRef {
+	return dbClient.Collection("tags").Doc(tag)
+}
+
+// namePrefixes returns every prefix of name, from its first rune to the
+// whole name.
+func namePrefixes(name string) []string {
+	prefixes := []string{}
+	for i := range name {
+		if i > 0 {
+			prefixes = append(prefixes, name[:i])
+		}
+	}
+	if name != "" {
+		prefixes = append(prefixes, name)
+	}
+	return prefixes
+}
+
+// incrementTagCounts adds a video to the counts of the tags as part of tx.
+func incrementTagCounts(tx *firestore.Transaction, tags []string) error {
+	for _, tag := range tags {
+		if err := tx.Set(tagRef(tag), map[string]interface{}{
+			"name":        tag,
+			"prefixes":    namePrefixes(tag),
+			"video_count": firestore.Increment(1),
+		}, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTagDocs reads the documents of the tags as part of tx, for
+// decrementTagCounts. Like every read in tx it must come before the writes.
+func getTagDocs(tx *firestore.Transaction, tags []string) ([]*firestore.DocumentSnapshot, error) {
+	refs := make([]*firestore.DocumentRef, 0, len(tags))
+	for _, tag := range tags {
+		refs = append(refs, tagRef(tag))
+	}
+	return tx.GetAll(refs)
+}
+
+// decrementTagCounts takes a video off the counts of the tags read with
+// getTagDocs as part of tx, deleting the tags no other video uses.
+func decrementTagCounts(tx *firestore.Transaction, tagDocs []*firestore.DocumentSnapshot) error {
+	for _, doc := range tagDocs {
+		if !doc.Exists() {
+			continue
+		}
+		var err error
+		if count, _ := doc.Data()["video_count"].(int64); count <= 1 {
+			err = tx.Delete(doc.Ref)
+		} else {
+			err = tx.Update(doc.Ref, []firestore.Update{{Path: "video_count", Value: firestore.Increment(-1)}})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTagVideos lists the videos with the tag, newest first.
+func GetTagVideos(c *gin.Context) {
+	tag := strings.ToLower(strings.TrimPrefix(c.Param("tag"), "#"))
+	userId := c.DefaultQuery("user_id", "")
+
+	scope := "tag:" + tag
+	req, ok := parsePageRequest(c, scope, tagPageLimits)
+	if !ok {
+		return
+	}
+
+	blockedIds, err := getBlockedIds(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch videos"})
+		return
+	}
+
+	query := dbClient.Collection("videos").Where("tags", "array-contains", tag).OrderBy("upload_time", firestore.Desc)
+	docs, nextCursor, err := pageQuery(query, "videos", scope, req)
+	if err != nil {
+		respondPageError(c, err, "Failed to fetch videos")
+		return
+	}
+
+	videoIds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		videoIds = append(videoIds, doc.Ref.ID)
+	}
+	found, err := loadVideosByIds(videoIds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch videos"})
+		return
+	}
+
+	videos := []Video{}
+	for _, id := range videoIds {
+		video, ok := found[id]
+		if !ok || blockedIds[id] || blockedIds[video.Uploader] {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	if err := attachVideoStats(videos, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.NewPage(videos, nextCursor))
+}
+
+// AutocompleteTags suggests the most used tags starting with prefix.
+func AutocompleteTags(c *gin.Context) {
+	prefix := strings.ToLower(strings.TrimPrefix(c.DefaultQuery("prefix", ""), "#"))
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prefix is missing"})
+		return
+	}
+
+	docs, err := dbClient.Collection("tags").
+		Where("prefixes", "array-contains", prefix).
+		OrderBy("video_count", firestore.Desc).
+		Limit(autocompleteLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+
+	tags := []Tag{}
+	for _, doc := range docs {
+		count, _ := doc.Data()["video_count"].(int64)
+		if count <= 0 {
+			continue
+		}
+		tags = append(tags, Tag{Name: doc.Data()["name"].(string), VideoCount: count})
+	}
+
+	c.JSON(http.StatusOK, pagination.NewPage(tags, ""))
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHashtags(t *testing.T) {
+	texts := map[string][]string{
+		"#Go and #go again":     {"go"},
+		"#한국어 #tag_1 #tag-2":    {"한국어", "tag_1", "tag"},
+		"#__name__ is reserved": {},
+		"#__ and #__x are not":  {"__", "__x"},
+		"no tags here # at all": {},
+		"#a#b":                  {"a", "b"},
+	}
+	for text, want := range texts {
+		if got := parseHashtags(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseHashtags(%q) = %q, want %q", text, got, want)
+		}
+	}
+
+	got := parseHashtags("#1 #2 #3 #4 #5 #6", "#7 #8 #9 #10 #11")
+	if len(got) != maxTagsPerVideo {
+		t.Errorf("got %d tags, want %d", len(got), maxTagsPerVideo)
+	}
+}
+
+func TestNamePrefixes(t *testing.T) {
+	if got, want := namePrefixes("한국"), []string{"한", "한국"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("namePrefixes = %q, want %q", got, want)
+	}
+	if got := namePrefixes(""); len(got) != 0 {
+		t.Errorf("namePrefixes of empty name = %q", got)
+	}
+}
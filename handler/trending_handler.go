@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"example.com/gobloc/pagination"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Trending scores count the likes and chat messages a video received within
+// trendingWindow, each weighted by how recent it is: an event loses half its
+// weight every trendingHalfLife. The job recomputes the list every
+// trendingInterval on the instance holding its lease and keeps the best
+// trendingSize videos.
+//
+// Likes and messages are counted as they are written, in activity counters
+// per video and trendingBucket of time, so the job reads one counter per
+// active video and hour instead of every like and message of the window.
+// Counters are sharded like the like counters and deleted once they fall out
+// of the window.
+var (
+	trendingInterval   = 5 * time.Minute
+	trendingWindow     = 24 * time.Hour
+	trendingBucket     = time.Hour
+	trendingHalfLife   = 3 * time.Hour
+	trendingLikeWeight = 1.0
+	trendingChatWeight = 0.3
+	trendingSize       = 50
+
+	trendingPageLimits = pagination.Limits{Default: 10, Max: 50}
+)
+
+func trendingRef() *firestore.DocumentRef {
+	return dbClient.Collection("trending").Doc("current")
+}
+
+// TrendingPage is a page of the trending list with the time it was computed.
+type TrendingPage struct {
+	pagination.Page[Video]
+	ComputedAt string `json:"computed_at"`
+}
+
+// trendingActivityRef returns a random shard of the video's activity counter
+// for the bucket starting at bucket.
+func trendingActivityRef(videoId string, bucket time.Time) *firestore.DocumentRef {
+	id := fmt.Sprintf("%s_%d_%d", videoId, bucket.Unix(), rand.Intn(likeShardCount))
+	return dbClient.Collection("trending_activity").Doc(id)
+}
+
+// trendingActivity returns the counter shard and the update adding delta to
+// the likes or chats counted for the video at time t. ok is false for events
+// older than the window, which are not counted anymore.
+func trendingActivity(videoId string, t time.Time, field string, delta int64) (ref *firestore.DocumentRef, data map[string]interface{}, ok bool) {
+	if time.Since(t) > trendingWindow {
+		return nil, nil, false
+	}
+	bucket := t.Truncate(trendingBucket)
+	return trendingActivityRef(videoId, bucket), map[string]interface{}{
+		"video_id": videoId,
+		"bucket":   bucket,
+		field:      firestore.Increment(delta),
+	}, true
+}
+
+// recordTrendingActivity counts activity on the video as part of tx.
+func recordTrendingActivity(tx *firestore.Transaction, videoId string, t time.Time, field string, delta int64) error {
+	ref, data, ok := trendingActivity(videoId, t, field, delta)
+	if !ok {
+		return nil
+	}
+	return tx.Set(ref, data, firestore.MergeAll)
+}
+
+// addTrendingActivity counts activity on the video outside a transaction.
+func addTrendingActivity(videoId string, t time.Time, field string, delta int64) error {
+	ref, data, ok := trendingActivity(videoId, t, field, delta)
+	if !ok {
+		return nil
+	}
+	_, err := ref.Set(ctx, data, firestore.MergeAll)
+	return err
+}
+
+// StartTrendingJob computes the trending list now and then periodically in
+// the background, on whichever instance holds the trending lease.
+func StartTrendingJob() {
+	go func() {
+		ticker := time.NewTicker(trendingInterval)
+		defer ticker.Stop()
+
+		for {
+			held, err := acquireJobLease("trending", 2*trendingInterval)
+			if err != nil {
+				log.Printf("failed to acquire trending lease: %v", err)
+			} else if held {
+				if err := computeTrending(time.Now()); err != nil {
+					log.Printf("trending computation failed: %v", err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// computeTrending scores the activity counters of the window ending at now,
+// stores the ranked video ids and deletes the counters that fell out of the
+// window. Each bucket is weighted as if its events happened at its middle.
+func computeTrending(now time.Time) error {
+	since := now.Add(-trendingWindow).Truncate(trendingBucket)
+	scores := make(map[string]float64)
+
+	buckets, err := dbClient.Collection("trending_activity").Where("bucket", ">=", since).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range buckets {
+		videoId, _ := doc.Data()["video_id"].(string)
+		bucket, _ := doc.Data()["bucket"].(time.Time)
+		likes, _ := doc.Data()["likes"].(int64)
+		chats, _ := doc.Data()["chats"].(int64)
+		weight := trendingDecay(now, bucket.Add(trendingBucket/2))
+		scores[videoId] += (trendingLikeWeight*float64(likes) + trendingChatWeight*float64(chats)) * weight
+	}
+
+	videoIds := make([]string, 0, len(scores))
+	for id := range scores {
+		// Unlikes of likes from before the counters were kept can leave a
+		// video below zero.
+		if id != "" && scores[id] > 0 {
+			videoIds = append(videoIds, id)
+		}
+	}
+	sort.Slice(videoIds, func(i, j int) bool {
+		if scores[videoIds[i]] != scores[videoIds[j]] {
+			return scores[videoIds[i]] > scores[videoIds[j]]
+		}
+		return videoIds[i] < videoIds[j]
+	})
+
+	// Deleted videos keep their like records and messages; drop them here
+	// rather than at read time so the stored list stays full.
+	trending := make([]string, 0, trendingSize)
+	trendingScores := make([]float64, 0, trendingSize)
+	for start := 0; start < len(videoIds) && len(trending) < trendingSize; start += trendingSize {
+		end := start + trendingSize
+		if end > len(videoIds) {
+			end = len(videoIds)
+		}
+		refs := make([]*firestore.DocumentRef, 0, end-start)
+		for _, id := range videoIds[start:end] {
+			refs = append(refs, dbClient.Collection("videos").Doc(id))
+		}
+		docs, err := dbClient.GetAll(ctx, refs)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if doc.Exists() && len(trending) < trendingSize {
+				trending = append(trending, doc.Ref.ID)
+				trendingScores = append(trendingScores, scores[doc.Ref.ID])
+			}
+		}
+	}
+
+	_, err = trendingRef().Set(ctx, map[string]interface{}{
+		"video_ids":   trending,
+		"scores":      trendingScores,
+		"computed_at": now,
+	})
+	if err != nil {
+		return err
+	}
+
+	expired, err := dbClient.Collection("trending_activity").Where("bucket", "<", since).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range expired {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			log.Printf("failed to delete trending_activity/%s: %v", doc.Ref.ID, err)
+		}
+	}
+	return nil
+}
+
+// trendingDecay is the weight of an event at time t when scoring at now.
+func trendingDecay(now time.Time, t time.Time) float64 {
+	age := now.Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	return math.Exp2(-float64(age) / float64(trendingHalfLife))
+}
+
+// GetTrendingVideos serves the last computed trending list, leaving out
+// videos the user blocked.
+func GetTrendingVideos(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", "")
+
+	doc, err := trendingRef().Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusOK, TrendingPage{Page: pagination.NewPage([]Video{}, "")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending videos"})
+		return
+	}
+	computedAt := doc.Data()["computed_at"].(time.Time)
+
+	// Cursors are offsets into one computed list and expire with it.
+	scope := "trending:" + computedAt.Format(time.RFC3339Nano)
+	req, ok := parsePageRequest(c, scope, trendingPageLimits)
+	if !ok {
+		return
+	}
+
+	blockedIds, err := getBlockedIds(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending videos"})
+		return
+	}
+
+	ids := stringsFromFirestore(doc.Data()["video_ids"])
+	start := req.Offset
+	if start > len(ids) {
+		start = len(ids)
+	}
+	end := start + req.Size
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	found, err := loadVideosByIds(ids[start:end])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending videos"})
+		return
+	}
+	videos := []Video{}
+	for _, id := range ids[start:end] {
+		video, ok := found[id]
+		if !ok || blockedIds[id] || blockedIds[video.Uploader] {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	if err := attachVideoStats(videos, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video stats"})
+		return
+	}
+
+	nextCursor := ""
+	if end < len(ids) {
+		nextCursor = pagination.AtOffset(scope, end)
+	}
+	c.JSON(http.StatusOK, TrendingPage{
+		Page:       pagination.NewPage(videos, nextCursor),
+		ComputedAt: computedAt.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+)
+
+// UserStats holds the counters kept per user in the user_stats collection.
+// They are updated in the same transaction as the records they count, except
+// TotalLikes, which is the sum of the like_count of the user's videos and is
+// advanced by the like compaction job as it folds like counter shards in.
+type UserStats struct {
+	FollowerCount  int64 `firestore:"follower_count" json:"follower_count"`
+	FollowingCount int64 `firestore:"following_count" json:"following_count"`
+	TotalLikes     int64 `firestore:"total_likes" json:"total_likes"`
+	VideoCount     int64 `firestore:"video_count" json:"video_count"`
+}
+
+func userStatsRef(userId string) *firestore.DocumentRef {
+	return dbClient.Collection("user_stats").Doc(userId)
+}
+
+// incrementUserStat adds delta to one of the user's counters as part of tx.
+func incrementUserStat(tx *firestore.Transaction, userId string, field string, delta int64) error {
+	return tx.Set(userStatsRef(userId), map[string]interface{}{
+		field: firestore.Increment(delta),
+	}, firestore.MergeAll)
+}
+
+// migrateUserStats computes the video and like counters of users whose stats
+// predate them. Each user is recounted once; later changes are applied as
+// increments.
+func migrateUserStats() error {
+	users, err := dbClient.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, user := range users {
+		if err := backfillUserStats(user.Ref.ID); err != nil {
+			log.Printf("failed to backfill stats of %s: %v", user.Ref.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d users were not backfilled", failed)
+	}
+	return nil
+}
+
+// backfillUserStats overwrites the user's video and like counters with a
+// count of their videos, unless that was already done. Counting in the
+// transaction keeps increments made meanwhile from being lost or counted
+// twice.
+func backfillUserStats(userId string) error {
+	statsRef := userStatsRef(userId)
+	return dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		statsDoc, err := tx.Get(statsRef)
+		if err == nil && statsDoc.Data()["backfilled"] == true {
+			return nil
+		}
+
+		videoDocs, err := tx.Documents(dbClient.Collection("videos").Where("uploader", "==", userId)).GetAll()
+		if err != nil {
+			return err
+		}
+
+		// Only like_count is summed: likes still in shards reach total_likes
+		// when the compaction job folds them in.
+		totalLikes := int64(0)
+		for _, video := range videoDocs {
+			likeCount, _ := video.Data()["like_count"].(int64)
+			totalLikes += likeCount
+		}
+
+		return tx.Set(statsRef, map[string]interface{}{
+			"video_count": int64(len(videoDocs)),
+			"total_likes": totalLikes,
+			"backfilled":  true,
+		}, firestore.MergeAll)
+	})
+}
+
+// getUserStats reads the counters of the users in one batch. Users without a
+// stats record get zero counters.
+func getUserStats(userIds []string) (map[string]UserStats, error) {
+	stats := make(map[string]UserStats)
+	if len(userIds) == 0 {
+		return stats, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(userIds))
+	for _, id := range userIds {
+		refs = append(refs, userStatsRef(id))
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		var userStats UserStats
+		if doc.Exists() {
+			if err := doc.DataTo(&userStats); err != nil {
+				return nil, err
+			}
+		}
+		stats[userIds[i]] = userStats
+	}
+	return stats, nil
+}
@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func UpdateUser(c *gin.Context) {
@@ -12,45 +15,54 @@ func UpdateUser(c *gin.Context) {
 	nickname := c.Request.PostFormValue("nickname")
 	intro := c.Request.PostFormValue("intro")
 
-	// Get the user document from Firestore.
+	// Update the user document and move the nickname reservation together,
+	// so that two users cannot take the same nickname. An unchanged nickname
+	// is kept as it is, even if it predates the nickname rules.
 	userDocRef := dbClient.Collection("users").Doc(userId)
-	userDoc, err := userDocRef.Get(ctx)
-	if err != nil {
-		c.AbortWithStatus(500)
-		return
-	}
-
-	if _, ok := userDoc.Data()["nickname"].(string); ok {
-		_, err := userDocRef.Update(ctx, []firestore.Update{{Path: "nickname", Value: nickname}})
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		userDoc, err := tx.Get(userDocRef)
 		if err != nil {
-			c.AbortWithStatus(500)
-			return
+			return err
 		}
-	} else {
-		_, err := userDocRef.Set(ctx, map[string]interface{}{
-			"nickname": nickname,
-		}, firestore.MergeAll)
-		if err != nil {
-			c.AbortWithStatus(500)
-			return
+		oldNickname, _ := userDoc.Data()["nickname"].(string)
+		if nickname != oldNickname {
+			if err := validateNickname(nickname); err != nil {
+				return err
+			}
+			done, err := isMigrationDone(nicknameReservationsMigration)
+			if err != nil {
+				return err
+			}
+			if !done {
+				return errNicknameLocked
+			}
+			if err := reserveNickname(tx, userId, oldNickname, nickname); err != nil {
+				return err
+			}
 		}
-	}
-
-	if _, ok := userDoc.Data()["introduction"].(string); ok {
-		_, err := userDocRef.Update(ctx, []firestore.Update{{Path: "introduction", Value: intro}})
-		if err != nil {
-			c.AbortWithStatus(500)
-			return
-		}
-	} else {
-		_, err := userDocRef.Set(ctx, map[string]interface{}{
+		return tx.Set(userDocRef, map[string]interface{}{
+			"nickname":     nickname,
 			"introduction": intro,
 		}, firestore.MergeAll)
-		if err != nil {
+	})
+	if err != nil {
+		switch {
+		case isInvalidNickname(err):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err == errNicknameLocked:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case err == errNicknameTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case status.Code(err) == codes.NotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+		default:
 			c.AbortWithStatus(500)
-			return
 		}
+		return
 	}
 
+	invalidateUserInfo(userId)
+	reindexUser(userId)
+
 	c.JSON(http.StatusOK, gin.H{"message": " updated successfully"})
 }
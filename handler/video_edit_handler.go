@@ -2,13 +2,18 @@ package handler
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
+	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func DeleteVideoHandler(c *gin.Context) {
@@ -101,10 +106,62 @@ func deleteVideoFromStorageAndDB(uniqueID uuid.UUID) error {
 		if err != nil {
 			return err
 		}
-		if _, err := doc.Ref.Delete(ctx); err != nil {
+		if err := deleteVideoDoc(doc.Ref); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// deleteVideoDoc deletes the video's document and like counter shards, takes
+// the video and its compacted likes off the uploader's stats and the video
+// off its tags' counts. Likes still in the shards never reached the
+// uploader's total.
+func deleteVideoDoc(videoRef *firestore.DocumentRef) error {
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		videoDoc, err := tx.Get(videoRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			return err
+		}
+		shards, err := tx.Documents(likeShardsRef(videoRef.ID)).GetAll()
+		if err != nil {
+			return err
+		}
+		tagDocs, err := getTagDocs(tx, stringsFromFirestore(videoDoc.Data()["tags"]))
+		if err != nil {
+			return err
+		}
+
+		likeCount, _ := videoDoc.Data()["like_count"].(int64)
+		for _, shard := range shards {
+			if err := tx.Delete(shard.Ref); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(videoRef); err != nil {
+			return err
+		}
+
+		if err := decrementTagCounts(tx, tagDocs); err != nil {
+			return err
+		}
+
+		uploader := videoDoc.Data()["uploader"].(string)
+		if err := incrementUserStat(tx, uploader, "video_count", -1); err != nil {
+			return err
+		}
+		return incrementUserStat(tx, uploader, "total_likes", -likeCount)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := searchIndex.Remove(searchKindVideo, videoRef.ID); err != nil {
+		log.Printf("failed to remove video %s from search: %v", videoRef.ID, err)
+	}
+	return nil
+}
@@ -5,22 +5,26 @@ import (
 	"net/http"
 	"time"
 
+	"example.com/gobloc/pagination"
+
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 )
 
 type Video struct {
-	Id       string `json:"id"`
-	Title    string `firestore:"title" json:"title"`
-	Uploader string `firestore:"uploader" json:"uploader"`
-	Url      string `firestore:"url" json:"url"`
-	//LikeCount   int    `firestore:"like_count" json:"like_count"`
-	Upload_time string `firestore:"upload_time" json:"upload_time"`
-	Thumbnail   string `firestore:"thumbnail" json:"thumbnail"`
-	IsNew       bool   `json:"is_new"`
-	//UserLiked   bool   `json:"user_liked"`
-	// ChatCount   int      `json:"chat_count"`
-	UserInfo UserInfo `json:"user_info"`
+	Id          string   `json:"id"`
+	Title       string   `firestore:"title" json:"title"`
+	Description string   `firestore:"description" json:"description"`
+	Tags        []string `firestore:"tags" json:"tags"`
+	Uploader    string   `firestore:"uploader" json:"uploader"`
+	Url         string   `firestore:"url" json:"url"`
+	LikeCount   int      `firestore:"like_count" json:"like_count"`
+	Upload_time string   `firestore:"upload_time" json:"upload_time"`
+	Thumbnail   string   `firestore:"thumbnail" json:"thumbnail"`
+	IsNew       bool     `json:"is_new"`
+	UserLiked   bool     `json:"user_liked"`
+	ChatCount   int      `json:"chat_count"`
+	UserInfo    UserInfo `json:"user_info"`
 }
 
 type UserInfo struct {
@@ -49,62 +53,72 @@ func getBlockedVideos(userID string) ([]string, error) {
 	return blockedVideos, nil
 }
 
+var videoPageLimits = pagination.Limits{Default: 10, Max: 30}
+
 func ReadUserVideos(c *gin.Context) {
 	userID := c.DefaultQuery("user_id", "")
+	viewerID := c.DefaultQuery("viewer_id", "")
 
-	videos, err := getUserVideosFromDatabase(userID)
+	scope := "user_videos:" + userID
+	req, ok := parsePageRequest(c, scope, videoPageLimits)
+	if !ok {
+		return
+	}
+
+	// The videos of a user the viewer cannot see are hidden, as on the
+	// profile.
+	blockedIds, err := getBlockedIds(viewerID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to fetch user videos: %v", err),
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if blockedIds[userID] {
+		c.JSON(http.StatusOK, pagination.NewPage([]Video{}, ""))
+		return
+	}
+
+	videos, nextCursor, err := getUserVideosFromDatabase(userID, scope, req)
+	if err != nil {
+		respondPageError(c, err, fmt.Sprintf("Failed to fetch user videos: %v", err))
 		return
 	}
 
-	if videos == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "No videos found",
+	if err := attachVideoStats(videos, viewerID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to fetch video stats: %v", err),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, videos)
+	c.JSON(http.StatusOK, pagination.NewPage(videos, nextCursor))
 }
 
-func getUserVideosFromDatabase(userID string) ([]Video, error) {
-	var videos []Video
-	docs, err := dbClient.Collection("videos").Where("uploader", "==", userID).OrderBy("upload_time", firestore.Desc).Documents(ctx).GetAll()
-
+// getUserVideosFromDatabase returns a page of the user's videos, newest
+// first.
+func getUserVideosFromDatabase(userID string, scope string, req pagination.Request) ([]Video, string, error) {
+	query := dbClient.Collection("videos").Where("uploader", "==", userID).OrderBy("upload_time", firestore.Desc)
+	docs, nextCursor, err := pageQuery(query, "videos", scope, req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
+	videos := []Video{}
 	for _, doc := range docs {
-		// videoID := doc.Ref.ID
-		// userLiked, err := checkUserLikedVideo(requestingUserID, videoID)
-		// chatCount, err2 := checkChatCount(doc.Data()["url"].(string))
-
-		// if err != nil {
-		// 	return nil, err
-		// }
-		// if err2 != nil {
-		// 	return nil, err2
-		// }
-
+		description, _ := doc.Data()["description"].(string)
 		video := Video{
-			Id:       doc.Ref.ID,
-			Title:    doc.Data()["title"].(string),
-			Uploader: doc.Data()["uploader"].(string),
-			Url:      doc.Data()["url"].(string),
-			//LikeCount:   int(doc.Data()["like_count"].(int64)),
+			Id:          doc.Ref.ID,
+			Title:       doc.Data()["title"].(string),
+			Description: description,
+			Tags:        stringsFromFirestore(doc.Data()["tags"]),
+			Uploader:    doc.Data()["uploader"].(string),
+			Url:         doc.Data()["url"].(string),
 			Upload_time: doc.Data()["upload_time"].(time.Time).Format(time.RFC3339),
 			Thumbnail:   doc.Data()["thumbnail"].(string),
-			//UserLiked:   userLiked,
-			// ChatCount:   chatCount,
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, nextCursor, nil
 }
 
 // func checkChatCount(videoUrl string) (int, error) {
@@ -117,143 +131,74 @@ func getUserVideosFromDatabase(userID string) ([]Video, error) {
 // }
 
 func ReadVideo(c *gin.Context) {
-	pageToken := c.DefaultQuery("pageToken", "0")
-	videoStr := c.DefaultQuery("first", "")
+	firstURL := c.DefaultQuery("first", "")
 	userId := c.DefaultQuery("user_id", "")
 
-	pageSize := 10
+	req, ok := parsePageRequest(c, "videos", videoPageLimits)
+	if !ok {
+		return
+	}
 
-	videos, err := getVideosFromDatabase(pageToken, pageSize, videoStr, userId)
+	videos, nextCursor, err := getVideosFromDatabase(req, firstURL, userId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch videos",
-		})
+		respondPageError(c, err, "Failed to fetch videos")
 		return
 	}
 
-	if videos == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Page not found",
+	if err := attachVideoStats(videos, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch video stats",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, videos)
+	c.JSON(http.StatusOK, pagination.NewPage(videos, nextCursor))
 }
 
-func getVideosFromDatabase(pageToken string, pageSize int, videoStr string, userId string) ([]Video, error) {
-	var videos []Video
-	isFirstblock := false
-	// Fetch blocked videos
-	blockedVideos, err := getBlockedVideos(userId)
+// getVideosFromDatabase returns a page of all videos, newest first, leaving
+// out the ones the user blocked. firstURL is the url of the newest video the
+// client has seen; videos uploaded after it are marked IsNew.
+func getVideosFromDatabase(req pagination.Request, firstURL string, userId string) ([]Video, string, error) {
+	blockedIds, err := getBlockedIds(userId)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	// Get the total count of videos
-	// docs, err := dbClient.Collection("videos").OrderBy("upload_time", firestore.Desc).Offset(page).Limit(pageSize).Documents(ctx).GetAll()
-	firstdoc, err2 := dbClient.Collection("videos").OrderBy("upload_time", firestore.Desc).Offset(0).Limit(1).Documents(ctx).GetAll()
+	query := dbClient.Collection("videos").OrderBy("upload_time", firestore.Desc)
+	docs, nextCursor, err := pageQuery(query, "videos", "videos", req)
 	if err != nil {
-		print(1111)
-		return nil, err
+		return nil, "", err
 	}
-	if err2 != nil {
-		print(1111)
-		return nil, err2
-	}
-
-	for _, blockedVideo := range blockedVideos {
-		if blockedVideo == firstdoc[0].Ref.ID {
-			isFirstblock = true
-		}
-	}
-	if firstdoc[0].Data()["url"].(string) != videoStr && pageToken != "" && !isFirstblock {
 
-		println("first!!")
-		userId := firstdoc[0].Data()["uploader"].(string)
-
-		user, err := dbClient.Collection("users").Doc(userId).Get(ctx)
+	var seenTime time.Time
+	if firstURL != "" {
+		seen, err := dbClient.Collection("videos").Where("url", "==", firstURL).Limit(1).Documents(ctx).GetAll()
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-
-		userInfo := UserInfo{
-			Id:        user.Data()["id"].(string),
-			Image:     user.Data()["image"].(string),
-			Thumbnail: user.Data()["thumbnail"].(string),
-			Nickname:  user.Data()["nickname"].(string),
-			Intro:     user.Data()["introduction"].(string),
+		if len(seen) > 0 {
+			seenTime = seen[0].Data()["upload_time"].(time.Time)
 		}
-		video := Video{
-			Id:          firstdoc[0].Ref.ID,
-			Title:       firstdoc[0].Data()["title"].(string),
-			Uploader:    firstdoc[0].Data()["uploader"].(string),
-			Url:         firstdoc[0].Data()["url"].(string),
-			Upload_time: firstdoc[0].Data()["upload_time"].(time.Time).Format(time.RFC3339),
-			//LikeCount:   int(firstdoc[0].Data()["like_count"].(int64)),
-			IsNew:    true,
-			UserInfo: userInfo,
-		}
-
-		videos = append(videos, video)
-	} else {
-		query := dbClient.Collection("videos").OrderBy("upload_time", firestore.Desc).Limit(pageSize)
-		if pageToken != "" {
-			doc, err := dbClient.Collection("videos").Doc(pageToken).Get(ctx)
-			if err != nil {
-				return nil, err
-			}
-			query = query.StartAfter(doc)
-		}
-
-		docs, err := query.Documents(ctx).GetAll()
-		if err != nil {
-			return nil, err
-		}
-
-		// Process videos
-		for _, doc := range docs {
-			creator := doc.Data()["uploader"].(string)
-
-			// Skip the video if the video is in the blocked list
-			isBlocked := false
-			for _, blockedVideo := range blockedVideos {
-				if blockedVideo == doc.Ref.ID {
-					isBlocked = true
-					break
-				}
-			}
-			if isBlocked {
-				continue
-			}
-
-			user, err := dbClient.Collection("users").Doc(creator).Get(ctx)
-			if err != nil {
-				return nil, err
-			}
-
-			userInfo := UserInfo{
-				Id:        user.Data()["id"].(string),
-				Image:     user.Data()["image"].(string),
-				Thumbnail: user.Data()["thumbnail"].(string),
-				Nickname:  user.Data()["nickname"].(string),
-				Intro:     user.Data()["introduction"].(string),
-			}
+	}
 
-			video := Video{
-				Id:          doc.Ref.ID,
-				Title:       doc.Data()["title"].(string),
-				Uploader:    doc.Data()["uploader"].(string),
-				Url:         doc.Data()["url"].(string),
-				Upload_time: doc.Data()["upload_time"].(time.Time).Format(time.RFC3339),
-				//LikeCount:   int(doc.Data()["like_count"].(int64)),
-				IsNew:    false,
-				UserInfo: userInfo,
-			}
+	videoIds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		videoIds = append(videoIds, doc.Ref.ID)
+	}
+	found, err := loadVideosByIds(videoIds)
+	if err != nil {
+		return nil, "", err
+	}
 
-			videos = append(videos, video)
+	videos := []Video{}
+	for _, doc := range docs {
+		video, ok := found[doc.Ref.ID]
+		if !ok || blockedIds[video.Id] || blockedIds[video.Uploader] {
+			continue
 		}
+		video.IsNew = !seenTime.IsZero() && doc.Data()["upload_time"].(time.Time).After(seenTime)
+		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, nextCursor, nil
 }
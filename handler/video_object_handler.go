@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,8 +23,9 @@ import (
 )
 
 type VideoObject struct {
-	Title    string `json:"title"`
-	Uploader string `json:"uploader"`
+	Title       string `json:"title"`
+	Uploader    string `json:"uploader"`
+	Description string `json:"description"`
 }
 
 type VideoData struct {
@@ -273,14 +275,32 @@ func uploadThumbnail(tmpDir string, uniqueID uuid.UUID) (string, error) {
 
 func uploadVideoInfoToFirestore(videoObject VideoObject, downloadURL, thumbnailURL string) error {
 
-	_, _, err := dbClient.Collection("videos").Add(ctx, map[string]interface{}{
-		"title":       videoObject.Title,
-		"uploader":    videoObject.Uploader,
-		"url":         downloadURL,
-		"thumbnail":   thumbnailURL, // Add thumbnail URL
-		"upload_time": time.Now(),
-		"like_count":  0,
+	tags := parseHashtags(videoObject.Title, videoObject.Description)
+
+	videoRef := dbClient.Collection("videos").NewDoc()
+	err := dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(videoRef, map[string]interface{}{
+			"title":       videoObject.Title,
+			"description": videoObject.Description,
+			"tags":        tags,
+			"uploader":    videoObject.Uploader,
+			"url":         downloadURL,
+			"thumbnail":   thumbnailURL, // Add thumbnail URL
+			"upload_time": time.Now(),
+			"like_count":  0,
+		}); err != nil {
+			return err
+		}
+		if err := incrementTagCounts(tx, tags); err != nil {
+			return err
+		}
+		return incrementUserStat(tx, videoObject.Uploader, "video_count", 1)
 	})
+	if err == nil {
+		if err := searchIndex.Index(videoSearchDocument(videoRef.ID, videoObject.Uploader, videoObject.Title, videoObject.Description, tags, 0)); err != nil {
+			log.Printf("failed to index video %s: %v", videoRef.ID, err)
+		}
+	}
 
 	userDocRef := dbClient.Collection("users").Doc(videoObject.Uploader)
 	_, err2 := userDocRef.Update(ctx, []firestore.Update{
@@ -290,6 +310,7 @@ func uploadVideoInfoToFirestore(videoObject VideoObject, downloadURL, thumbnailU
 	if err2 != nil {
 		return err2
 	}
+	invalidateUserInfo(videoObject.Uploader)
 
 	return err
 }
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// attachVideoStats fills in the like count, chat count and viewer-liked state
+// of the videos. Counters, shards and like records are each read in one batch
+// instead of per video.
+func attachVideoStats(videos []Video, viewerId string) error {
+	if len(videos) == 0 {
+		return nil
+	}
+
+	videoRefs := make([]*firestore.DocumentRef, 0, len(videos))
+	for _, video := range videos {
+		videoRefs = append(videoRefs, dbClient.Collection("videos").Doc(video.Id))
+	}
+	videoDocs, err := dbClient.GetAll(ctx, videoRefs)
+	if err != nil {
+		return err
+	}
+
+	likeCounts, err := getLikeCounts(videoDocs)
+	if err != nil {
+		return err
+	}
+	liked, err := getViewerLikes(videos, viewerId)
+	if err != nil {
+		return err
+	}
+
+	chatCounts, err := getChatCounts(videoDocs)
+	if err != nil {
+		return err
+	}
+
+	for i := range videos {
+		videos[i].LikeCount = likeCounts[videos[i].Id]
+		videos[i].UserLiked = liked[videos[i].Id]
+		videos[i].ChatCount = int(chatCounts[videos[i].Id])
+	}
+	return nil
+}
+
+// getChatCounts returns the chat counts of the videos. Videos the chat_counts
+// migration has not reached yet are counted with aggregation queries, run
+// concurrently and without writing; the migration backfills them.
+func getChatCounts(videoDocs []*firestore.DocumentSnapshot) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	var missing []string
+	for _, doc := range videoDocs {
+		if !doc.Exists() {
+			continue
+		}
+		if count, ok := doc.Data()["chat_count"].(int64); ok {
+			counts[doc.Ref.ID] = count
+		} else {
+			missing = append(missing, doc.Ref.ID)
+		}
+	}
+
+	stored := make([]int, len(missing))
+	errs := make([]error, len(missing))
+	var wg sync.WaitGroup
+	for i, videoId := range missing {
+		wg.Add(1)
+		go func(i int, videoId string) {
+			defer wg.Done()
+			stored[i], errs[i] = getMessageDocCount(videoId)
+		}(i, videoId)
+	}
+	wg.Wait()
+
+	for i, videoId := range missing {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		counts[videoId] = int64(stored[i])
+	}
+	return counts, nil
+}
+
+// getLikeCounts returns the like counts of the videos, reading the ones
+// missing from the like count cache in one batch.
+func getLikeCounts(videoDocs []*firestore.DocumentSnapshot) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	var missing []string
+	likeCountCacheLock.Lock()
+	for _, doc := range videoDocs {
+		if !doc.Exists() {
+			continue
+		}
+		if cached, ok := likeCountCache[doc.Ref.ID]; ok && time.Now().Before(cached.expires) {
+			counts[doc.Ref.ID] = cached.count
+			continue
+		}
+		missing = append(missing, doc.Ref.ID)
+	}
+	likeCountCacheLock.Unlock()
+
+	read, err := readLikeCounts(missing)
+	if err != nil {
+		return nil, err
+	}
+	for videoId, count := range read {
+		counts[videoId] = count
+	}
+	return counts, nil
+}
+
+// getViewerLikes reports which of the videos the viewer liked. Until the
+// like_records migration completed, likes without a record are looked up in
+// the viewer's user_likes document, as isVideoLiked does.
+func getViewerLikes(videos []Video, viewerId string) (map[string]bool, error) {
+	liked := make(map[string]bool)
+	if viewerId == "" {
+		return liked, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(videos))
+	for _, video := range videos {
+		refs = append(refs, likeRef(viewerId, video.Id))
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		if doc.Exists() {
+			liked[videos[i].Id] = true
+		}
+	}
+
+	done, err := isMigrationDone(likeRecordsMigration)
+	if err != nil || done {
+		return liked, err
+	}
+	legacy, err := legacyLikesRef(viewerId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return liked, nil
+		}
+		return nil, err
+	}
+	for _, videoId := range legacyLikedVideos(legacy) {
+		liked[videoId] = true
+	}
+	return liked, nil
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Watch completion is kept per video in video_watch_stats as the number of
+// viewers who reported watching it and the sum of their completion
+// fractions. Each viewer's best completion is kept in video_watches, so
+// repeated reports do not inflate the stats.
+func watchStatsRef(videoId string) *firestore.DocumentRef {
+	return dbClient.Collection("video_watch_stats").Doc(videoId)
+}
+
+func watchRef(userId string, videoId string) *firestore.DocumentRef {
+	return dbClient.Collection("video_watches").Doc(userId + "_" + videoId)
+}
+
+// RecordWatch records that user_id watched watched_seconds of a video that
+// is duration_seconds long. A viewer counts once per video, with the highest
+// completion they reported.
+func RecordWatch(c *gin.Context) {
+	videoId := c.Param("id")
+	userId := c.PostForm("user_id")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	watched, err := strconv.ParseFloat(c.PostForm("watched_seconds"), 64)
+	if err != nil || watched < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid watched_seconds"})
+		return
+	}
+	duration, err := strconv.ParseFloat(c.PostForm("duration_seconds"), 64)
+	if err != nil || duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration_seconds"})
+		return
+	}
+
+	completion := watched / duration
+	if completion > 1 {
+		completion = 1
+	}
+
+	err = recordWatch(userId, videoId, completion)
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record watch"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Watch recorded"})
+}
+
+// recordWatch raises the viewer's completion of the video to completion and
+// adds the difference to the video's stats, counting the viewer the first
+// time. The record and the stats are written in one transaction.
+func recordWatch(userId string, videoId string, completion float64) error {
+	videoRef := dbClient.Collection("videos").Doc(videoId)
+	ref := watchRef(userId, videoId)
+	return dbClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(videoRef); err != nil {
+			return err
+		}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		var views int64 = 1
+		previous := 0.0
+		if doc.Exists() {
+			views = 0
+			previous, _ = doc.Data()["completion"].(float64)
+		}
+		if doc.Exists() && completion <= previous {
+			return nil
+		}
+
+		if err := tx.Set(ref, map[string]interface{}{
+			"user_id":    userId,
+			"video_id":   videoId,
+			"completion": completion,
+			"updated_at": time.Now(),
+		}); err != nil {
+			return err
+		}
+		return tx.Set(watchStatsRef(videoId), map[string]interface{}{
+			"view_count":     firestore.Increment(views),
+			"completion_sum": firestore.Increment(completion - previous),
+		}, firestore.MergeAll)
+	})
+}
+
+type watchStats struct {
+	ViewCount     int
+	CompletionSum float64
+}
+
+// getWatchStats reads the watch stats of the videos in one batch.
+func getWatchStats(videoIds []string) (map[string]watchStats, error) {
+	stats := make(map[string]watchStats)
+	if len(videoIds) == 0 {
+		return stats, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(videoIds))
+	for _, id := range videoIds {
+		refs = append(refs, watchStatsRef(id))
+	}
+	docs, err := dbClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		viewCount, _ := doc.Data()["view_count"].(int64)
+		completionSum, ok := doc.Data()["completion_sum"].(float64)
+		if !ok {
+			// Increments of whole numbers leave an integer behind.
+			sum, _ := doc.Data()["completion_sum"].(int64)
+			completionSum = float64(sum)
+		}
+		stats[videoIds[i]] = watchStats{ViewCount: int(viewCount), CompletionSum: completionSum}
+	}
+	return stats, nil
+}
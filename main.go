@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 
 	"example.com/gobloc/handler"
 
@@ -9,21 +11,65 @@ import (
 )
 
 func main() {
+	migrate := flag.String("migrate", "", "run the named one-off data migration and exit")
+	flag.Parse()
+
 	handler.Init()
+	if *migrate != "" {
+		if err := handler.RunMigration(*migrate); err != nil {
+			log.Fatalf("migration %s failed: %v", *migrate, err)
+		}
+		return
+	}
+
+	handler.StartLikeCompaction()
+	handler.StartTrendingJob()
 	router := gin.Default()
 	router.MaxMultipartMemory = 8 << 20 // 8 MiB
 	router.POST("/multiupload", handler.HandleImageMultiUpload)
 	router.GET("/ws", handler.HandleWebSocket)
+	router.GET("/chat/stream", handler.HandleEventStream)
+	router.GET("/chat/poll", handler.PollEvents)
+	router.POST("/chat/events", handler.PostEvent)
+	router.GET("/rooms/settings", handler.GetRoomSettings)
+	router.POST("/rooms/settings", handler.UpdateRoomSettings)
+	router.POST("/rooms/pin", handler.PinMessage)
+	router.POST("/rooms/unpin", handler.UnpinMessage)
 	router.GET("/videos", handler.ReadVideo)
+	router.GET("/feed/following", handler.GetFollowingFeed)
+	router.GET("/feed/foryou", handler.GetForYouFeed)
+	router.GET("/videos/trending", handler.GetTrendingVideos)
+	router.GET("/tags", handler.AutocompleteTags)
+	router.GET("/tags/:tag/videos", handler.GetTagVideos)
+	router.GET("/search", handler.Search)
+	router.GET("/nicknames/check", handler.CheckNickname)
+	router.POST("/videos/:id/watch", handler.RecordWatch)
 	router.GET("/mypage", handler.GetMyPage)
 	router.GET("/user_videos", handler.ReadUserVideos)
 	router.POST("/uploads", handler.VideoObjectHandler)
 	router.POST("/login", handler.LoginHandler)
 	router.GET("/follow", handler.GetFollowingUsersInfo)
+	router.POST("/follow", handler.ToggleFollow)
+	router.PUT("/users/:id/following/:targetID", handler.FollowUser)
+	router.GET("/users/:id/profile", handler.GetProfile)
+	router.GET("/users/:id/followers", handler.GetFollowers)
+	router.GET("/users/:id/following", handler.GetFollowing)
+	router.DELETE("/users/:id/following/:targetID", handler.UnfollowUser)
 	router.POST("/delete", handler.DeleteVideoHandler)
 	router.POST("/update", handler.UpdateUser)
 	router.POST("/remove", handler.RemoveHandler)
 	router.POST("/block", handler.BlcokHandler)
+	router.PUT("/admin/users/:id/ban", handler.BanUser)
+	router.DELETE("/admin/users/:id/ban", handler.UnbanUser)
+	router.GET("/unread", handler.GetUnreadCounts)
+	router.GET("/notifications", handler.GetNotifications)
+	router.POST("/notifications/read", handler.MarkNotificationsRead)
+	router.POST("/chat/attachments", handler.HandleAttachmentUpload)
+	router.GET("/chat/search", handler.SearchMessages)
+	router.GET("/users/:id/likes", handler.GetLikedVideos)
+	router.POST("/users/:id/likes/:videoID", handler.UpdateLikes)
+	router.PUT("/users/:id/likes/:videoID", handler.LikeVideo)
+	router.DELETE("/users/:id/likes/:videoID", handler.UnlikeVideo)
 	fmt.Println("start")
 	//router.RunTLS(":443", "./cert.pem", "./key.pem")
 	router.Run(":8080")
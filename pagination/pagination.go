@@ -0,0 +1,179 @@
+// Package pagination implements the cursors and the response envelope shared
+// by the list endpoints.
+//
+// A cursor is opaque to clients: it is the JSON encoded position of the last
+// item of a page, signed with HMAC-SHA256 so that clients cannot forge
+// positions, and bound to a scope naming the list it belongs to so that a
+// cursor of one list is rejected by another.
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrInvalidPageSize = errors.New("invalid page_size")
+)
+
+// Limits are the default and maximum page size of a list. Larger requested
+// sizes are clamped to Max.
+type Limits struct {
+	Default int
+	Max     int
+}
+
+var DefaultLimits = Limits{Default: 20, Max: 50}
+
+// Request is a parsed page request. After is the id of the last item of the
+// previous page and Offset the number of items before this page; lists use
+// whichever fits how they are stored. Both are zero for the first page.
+// AsOf is the time the first page of an offset list was computed at, zero
+// when the cursor carries none.
+type Request struct {
+	After  string
+	Offset int
+	AsOf   time.Time
+	Size   int
+}
+
+// Page is the response envelope of every list endpoint.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// NewPage wraps items in the envelope. nextCursor is empty on the last page.
+func NewPage[T any](items []T, nextCursor string) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return Page[T]{
+		Items:      items,
+		NextCursor: nextCursor,
+		HasMore:    nextCursor != "",
+	}
+}
+
+type position struct {
+	Scope  string `json:"s"`
+	After  string `json:"a,omitempty"`
+	Offset int    `json:"o,omitempty"`
+	AsOf   int64  `json:"t,omitempty"`
+}
+
+var (
+	secret     []byte
+	secretOnce sync.Once
+)
+
+// signingKey returns the key cursors are signed with, taken from the
+// PAGINATION_SECRET environment variable. Without it a random key is used,
+// and cursors do not survive a restart or work across instances.
+func signingKey() []byte {
+	secretOnce.Do(func() {
+		if key := os.Getenv("PAGINATION_SECRET"); key != "" {
+			secret = []byte(key)
+			return
+		}
+		log.Printf("PAGINATION_SECRET is not set; using a random cursor key")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	})
+	return secret
+}
+
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func encode(pos position) string {
+	payload, _ := json.Marshal(pos)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(payload))
+}
+
+// After returns the cursor for the page following the item with id after.
+func After(scope string, after string) string {
+	return encode(position{Scope: scope, After: after})
+}
+
+// AtOffset returns the cursor for the page starting at offset.
+func AtOffset(scope string, offset int) string {
+	return encode(position{Scope: scope, Offset: offset})
+}
+
+// AtOffsetAsOf returns the cursor for the page starting at offset of a list
+// computed at asOf. Lists whose order changes over time serve later pages
+// from the list computed at that time so that items are not skipped or
+// repeated.
+func AtOffsetAsOf(scope string, offset int, asOf time.Time) string {
+	return encode(position{Scope: scope, Offset: offset, AsOf: asOf.UnixNano()})
+}
+
+func decode(scope string, cursor string) (position, error) {
+	encodedPayload, encodedMac, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return position{}, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return position{}, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, sign(payload)) {
+		return position{}, ErrInvalidCursor
+	}
+
+	var pos position
+	if err := json.Unmarshal(payload, &pos); err != nil || pos.Scope != scope || pos.Offset < 0 {
+		return position{}, ErrInvalidCursor
+	}
+	return pos, nil
+}
+
+// Parse reads the cursor and page_size query values of a request for the
+// list named by scope. Empty values select the first page and the default
+// size.
+func Parse(scope string, cursor string, pageSize string, limits Limits) (Request, error) {
+	req := Request{Size: limits.Default}
+
+	if pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size <= 0 {
+			return Request{}, ErrInvalidPageSize
+		}
+		req.Size = size
+	}
+	if req.Size > limits.Max {
+		req.Size = limits.Max
+	}
+
+	if cursor != "" {
+		pos, err := decode(scope, cursor)
+		if err != nil {
+			return Request{}, err
+		}
+		req.After = pos.After
+		req.Offset = pos.Offset
+		if pos.AsOf != 0 {
+			req.AsOf = time.Unix(0, pos.AsOf)
+		}
+	}
+	return req, nil
+}
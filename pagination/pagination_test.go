@@ -0,0 +1,119 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	req, err := Parse("followers:u1", After("followers:u1", "u2"), "", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.After != "u2" || req.Offset != 0 {
+		t.Errorf("After cursor parsed to %+v", req)
+	}
+
+	req, err = Parse("feed:u1", AtOffset("feed:u1", 40), "", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.After != "" || req.Offset != 40 || !req.AsOf.IsZero() {
+		t.Errorf("AtOffset cursor parsed to %+v", req)
+	}
+
+	asOf := time.Date(2026, 10, 19, 10, 0, 0, 123, time.UTC)
+	req, err = Parse("feed:u1", AtOffsetAsOf("feed:u1", 10, asOf), "", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Offset != 10 || !req.AsOf.Equal(asOf) {
+		t.Errorf("AtOffsetAsOf cursor parsed to %+v", req)
+	}
+}
+
+func TestFirstPage(t *testing.T) {
+	req, err := Parse("feed:u1", "", "", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req != (Request{Size: DefaultLimits.Default}) {
+		t.Errorf("first page = %+v", req)
+	}
+}
+
+func TestCursorOfAnotherScope(t *testing.T) {
+	if _, err := Parse("followers:u1", After("following:u1", "u2"), "", DefaultLimits); err != ErrInvalidCursor {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+	if _, err := Parse("followers:u1", After("followers:u3", "u2"), "", DefaultLimits); err != ErrInvalidCursor {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestTamperedCursor(t *testing.T) {
+	cursor := AtOffset("feed:u1", 20)
+	encodedPayload, encodedMac, _ := strings.Cut(cursor, ".")
+
+	// Moving the cursor forward without re-signing it.
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"feed:u1","o":1000}`)) + "." + encodedMac
+
+	mac, _ := base64.RawURLEncoding.DecodeString(encodedMac)
+	mac[0] ^= 1
+	flipped := encodedPayload + "." + base64.RawURLEncoding.EncodeToString(mac)
+
+	cursors := map[string]string{
+		"forged payload":   forged,
+		"flipped mac bit":  flipped,
+		"missing mac":      encodedPayload,
+		"empty mac":        encodedPayload + ".",
+		"bad base64":       "!!!." + encodedMac,
+		"negative offset":  encode(position{Scope: "feed:u1", Offset: -20}),
+		"not json payload": base64.RawURLEncoding.EncodeToString([]byte("x")) + "." + base64.RawURLEncoding.EncodeToString(sign([]byte("x"))),
+	}
+	for name, cursor := range cursors {
+		if _, err := Parse("feed:u1", cursor, "", DefaultLimits); err != ErrInvalidCursor {
+			t.Errorf("%s: err = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	limits := Limits{Default: 20, Max: 50}
+	sizes := map[string]int{
+		"":    20,
+		"1":   1,
+		"50":  50,
+		"51":  50,
+		"999": 50,
+	}
+	for pageSize, want := range sizes {
+		req, err := Parse("feed:u1", "", pageSize, limits)
+		if err != nil {
+			t.Errorf("page_size %q: %v", pageSize, err)
+			continue
+		}
+		if req.Size != want {
+			t.Errorf("page_size %q: size %d, want %d", pageSize, req.Size, want)
+		}
+	}
+
+	for _, pageSize := range []string{"0", "-1", "ten", "1.5"} {
+		if _, err := Parse("feed:u1", "", pageSize, limits); err != ErrInvalidPageSize {
+			t.Errorf("page_size %q: err = %v, want ErrInvalidPageSize", pageSize, err)
+		}
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	last := NewPage[int](nil, "")
+	if last.Items == nil || last.HasMore {
+		t.Errorf("last page = %+v, want empty items and no more", last)
+	}
+	page := NewPage([]int{1, 2}, "next")
+	if !page.HasMore || page.NextCursor != "next" {
+		t.Errorf("page = %+v, want more", page)
+	}
+}
@@ -0,0 +1,45 @@
+package ranking
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Arm is one ranker of an experiment and its share of users.
+type Arm struct {
+	Ranker Ranker
+	Weight int
+}
+
+// Experiment splits users between rankers. A user always lands in the same
+// arm of an experiment, and the split of one experiment is independent of
+// the split of another with a different name.
+type Experiment struct {
+	Name string
+	Arms []Arm
+}
+
+// Assign returns the ranker for the user.
+func (e Experiment) Assign(userId string) Ranker {
+	total := 0
+	for _, arm := range e.Arms {
+		total += arm.Weight
+	}
+	if total <= 0 {
+		return Balanced()
+	}
+
+	// FNV and similar multiplicative hashes keep the low bits of inputs that
+	// share a suffix correlated, which would split users the same way in
+	// every experiment; SHA-256 mixes all bits.
+	hash := sha256.Sum256([]byte(e.Name + ":" + userId))
+	bucket := int(binary.BigEndian.Uint64(hash[:8]) % uint64(total))
+
+	for _, arm := range e.Arms {
+		if bucket < arm.Weight {
+			return arm.Ranker
+		}
+		bucket -= arm.Weight
+	}
+	return e.Arms[len(e.Arms)-1].Ranker
+}
@@ -0,0 +1,70 @@
+package ranking
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAssignIsStable(t *testing.T) {
+	experiment := Experiment{
+		Name: "foryou",
+		Arms: []Arm{{Ranker: Balanced(), Weight: 50}, {Ranker: Engagement(), Weight: 50}},
+	}
+	for i := 0; i < 100; i++ {
+		userId := fmt.Sprintf("user-%d", i)
+		first := experiment.Assign(userId).Name
+		for j := 0; j < 3; j++ {
+			if got := experiment.Assign(userId).Name; got != first {
+				t.Fatalf("%s assigned %s, then %s", userId, first, got)
+			}
+		}
+	}
+}
+
+func TestAssignFollowsWeights(t *testing.T) {
+	experiment := Experiment{
+		Name: "weights",
+		Arms: []Arm{
+			{Ranker: Ranker{Name: "small"}, Weight: 10},
+			{Ranker: Ranker{Name: "large"}, Weight: 90},
+		},
+	}
+	const users = 10000
+	counts := map[string]int{}
+	for i := 0; i < users; i++ {
+		counts[experiment.Assign(fmt.Sprintf("user-%d", i)).Name]++
+	}
+	if share := float64(counts["small"]) / users; math.Abs(share-0.1) > 0.02 {
+		t.Errorf("small arm got %.3f of users, want about 0.1", share)
+	}
+}
+
+func TestAssignIsIndependentPerExperiment(t *testing.T) {
+	arms := []Arm{{Ranker: Ranker{Name: "a"}, Weight: 1}, {Ranker: Ranker{Name: "b"}, Weight: 1}}
+	first := Experiment{Name: "first", Arms: arms}
+	second := Experiment{Name: "second", Arms: arms}
+
+	const users = 10000
+	same := 0
+	for i := 0; i < users; i++ {
+		userId := fmt.Sprintf("user-%d", i)
+		if first.Assign(userId).Name == second.Assign(userId).Name {
+			same++
+		}
+	}
+	// Independent halves agree for about half of the users.
+	if share := float64(same) / users; math.Abs(share-0.5) > 0.03 {
+		t.Errorf("experiments agree for %.3f of users, want about 0.5", share)
+	}
+}
+
+func TestAssignWithoutArms(t *testing.T) {
+	if got := (Experiment{Name: "empty"}).Assign("user-1").Name; got != Balanced().Name {
+		t.Errorf("empty experiment assigned %s, want %s", got, Balanced().Name)
+	}
+	zero := Experiment{Name: "zero", Arms: []Arm{{Ranker: Engagement(), Weight: 0}}}
+	if got := zero.Assign("user-1").Name; got != Balanced().Name {
+		t.Errorf("zero weight experiment assigned %s, want %s", got, Balanced().Name)
+	}
+}
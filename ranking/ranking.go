@@ -0,0 +1,88 @@
+// Package ranking orders candidate videos for the ranked feeds. A Ranker sums
+// the weighted scores of its Scorers for each candidate; scorers are
+// independent and can be combined freely.
+package ranking
+
+import (
+	"sort"
+	"time"
+)
+
+// Candidate is a video considered for a feed, with the signals scorers use.
+type Candidate struct {
+	VideoId    string
+	UploaderId string
+	UploadTime time.Time
+	LikeCount  int
+	ChatCount  int
+
+	// WatchCompletion is the average fraction of the video watched, from 0
+	// to 1, over WatchCount views.
+	WatchCompletion float64
+	WatchCount      int
+
+	// ViewerFollowsUploader tells whether the viewer follows the uploader.
+	ViewerFollowsUploader bool
+}
+
+// Context is what scorers know about the request. Now is passed in rather
+// than read from the clock so that rankings are reproducible.
+type Context struct {
+	ViewerId string
+	Now      time.Time
+}
+
+// Scorer scores one aspect of a candidate. Scores are expected to fall
+// roughly between 0 and 1 so that weights are comparable.
+type Scorer interface {
+	Name() string
+	Score(ctx Context, candidate Candidate) float64
+}
+
+// Weighted is a scorer with the weight of its score in a ranker.
+type Weighted struct {
+	Scorer Scorer
+	Weight float64
+}
+
+// Ranker orders candidates by the weighted sum of its scorers.
+type Ranker struct {
+	Name    string
+	Scorers []Weighted
+}
+
+// Scored is a candidate with its total score and the score of each scorer,
+// keyed by scorer name.
+type Scored struct {
+	Candidate Candidate
+	Score     float64
+	Breakdown map[string]float64
+}
+
+// Rank scores the candidates and returns them best first. Ties are broken by
+// upload time, newest first, and then by video id, so equal input always
+// gives the same order.
+func (r Ranker) Rank(ctx Context, candidates []Candidate) []Scored {
+	scored := make([]Scored, 0, len(candidates))
+	for _, candidate := range candidates {
+		entry := Scored{Candidate: candidate, Breakdown: make(map[string]float64, len(r.Scorers))}
+		for _, weighted := range r.Scorers {
+			score := weighted.Weight * weighted.Scorer.Score(ctx, candidate)
+			entry.Breakdown[weighted.Scorer.Name()] = score
+			entry.Score += score
+		}
+		scored = append(scored, entry)
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		a, b := scored[i], scored[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Candidate.UploadTime.Equal(b.Candidate.UploadTime) {
+			return a.Candidate.UploadTime.After(b.Candidate.UploadTime)
+		}
+		return a.Candidate.VideoId < b.Candidate.VideoId
+	})
+	return scored
+}
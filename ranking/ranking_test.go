@@ -0,0 +1,108 @@
+package ranking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixtureCandidates are a fresh video without engagement, an older one people
+// like, talk about and watch through, and one from a followed creator in
+// between.
+func fixtureCandidates() []Candidate {
+	return []Candidate{
+		{VideoId: "fresh", UploaderId: "a", UploadTime: fixtureNow.Add(-time.Hour)},
+		{
+			VideoId: "popular", UploaderId: "b", UploadTime: fixtureNow.Add(-72 * time.Hour),
+			LikeCount: 900, ChatCount: 400, WatchCount: 50, WatchCompletion: 0.9,
+		},
+		{
+			VideoId: "followed", UploaderId: "c", UploadTime: fixtureNow.Add(-24 * time.Hour),
+			LikeCount: 20, WatchCount: 10, WatchCompletion: 0.6, ViewerFollowsUploader: true,
+		},
+	}
+}
+
+func rankedIds(scored []Scored) []string {
+	ids := make([]string, 0, len(scored))
+	for _, entry := range scored {
+		ids = append(ids, entry.Candidate.VideoId)
+	}
+	return ids
+}
+
+func TestRankersOrderFixtures(t *testing.T) {
+	rankers := map[string][]string{
+		Balanced().Name:   {"popular", "followed", "fresh"},
+		Engagement().Name: {"popular", "followed", "fresh"},
+	}
+	for _, ranker := range []Ranker{Balanced(), Engagement()} {
+		got := rankedIds(ranker.Rank(Context{ViewerId: "viewer", Now: fixtureNow}, fixtureCandidates()))
+		if want := rankers[ranker.Name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s ranked %v, want %v", ranker.Name, got, want)
+		}
+	}
+}
+
+func TestRankersDisagree(t *testing.T) {
+	// Balanced prefers the fresh video, Engagement the older one people
+	// like and watch through.
+	candidates := []Candidate{
+		{VideoId: "fresh", UploadTime: fixtureNow.Add(-time.Hour)},
+		{VideoId: "liked", UploadTime: fixtureNow.Add(-72 * time.Hour), LikeCount: 300, WatchCount: 10, WatchCompletion: 0.7},
+	}
+	ctx := Context{Now: fixtureNow}
+	if got := rankedIds(Balanced().Rank(ctx, candidates)); got[0] != "fresh" {
+		t.Errorf("balanced ranked %v, want fresh first", got)
+	}
+	if got := rankedIds(Engagement().Rank(ctx, candidates)); got[0] != "liked" {
+		t.Errorf("engagement ranked %v, want liked first", got)
+	}
+}
+
+func TestRankBreakdown(t *testing.T) {
+	ranker := Ranker{
+		Name: "test",
+		Scorers: []Weighted{
+			{Scorer: Likes{Saturation: 100}, Weight: 2},
+			{Scorer: FollowAffinity{}, Weight: 0.5},
+		},
+	}
+	scored := ranker.Rank(Context{Now: fixtureNow}, []Candidate{{VideoId: "v", LikeCount: 100, ViewerFollowsUploader: true}})
+
+	want := map[string]float64{"likes": 2, "follow_affinity": 0.5}
+	if !reflect.DeepEqual(scored[0].Breakdown, want) {
+		t.Errorf("breakdown %v, want %v", scored[0].Breakdown, want)
+	}
+	if !approxEqual(scored[0].Score, 2.5) {
+		t.Errorf("score %v, want 2.5", scored[0].Score)
+	}
+}
+
+func TestRankBreaksTies(t *testing.T) {
+	// Without scorers every candidate scores 0, so only the tie breaks
+	// decide: newest first, then by video id.
+	candidates := []Candidate{
+		{VideoId: "b", UploadTime: fixtureNow},
+		{VideoId: "old", UploadTime: fixtureNow.Add(-time.Hour)},
+		{VideoId: "c", UploadTime: fixtureNow},
+		{VideoId: "a", UploadTime: fixtureNow},
+	}
+	want := []string{"a", "b", "c", "old"}
+
+	for i := 0; i < len(candidates); i++ {
+		rotated := append(append([]Candidate{}, candidates[i:]...), candidates[:i]...)
+		if got := rankedIds(Ranker{}.Rank(Context{Now: fixtureNow}, rotated)); !reflect.DeepEqual(got, want) {
+			t.Errorf("rotation %d ranked %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestRankIsReproducible(t *testing.T) {
+	ctx := Context{ViewerId: "viewer", Now: fixtureNow}
+	first := Balanced().Rank(ctx, fixtureCandidates())
+	second := Balanced().Rank(ctx, fixtureCandidates())
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("ranking the same input twice differed:\n%v\n%v", first, second)
+	}
+}
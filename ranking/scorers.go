@@ -0,0 +1,109 @@
+package ranking
+
+import (
+	"math"
+	"time"
+)
+
+// Recency decays from 1 for a video uploaded now, halving every HalfLife.
+type Recency struct {
+	HalfLife time.Duration
+}
+
+func (s Recency) Name() string { return "recency" }
+
+func (s Recency) Score(ctx Context, candidate Candidate) float64 {
+	age := ctx.Now.Sub(candidate.UploadTime)
+	if age < 0 {
+		age = 0
+	}
+	return math.Exp2(-float64(age) / float64(s.HalfLife))
+}
+
+// Likes scores the like count on a log scale, reaching 1 at Saturation likes.
+type Likes struct {
+	Saturation int
+}
+
+func (s Likes) Name() string { return "likes" }
+
+func (s Likes) Score(ctx Context, candidate Candidate) float64 {
+	return logScale(candidate.LikeCount, s.Saturation)
+}
+
+// Chat scores the number of chat messages on a log scale, reaching 1 at
+// Saturation messages.
+type Chat struct {
+	Saturation int
+}
+
+func (s Chat) Name() string { return "chat" }
+
+func (s Chat) Score(ctx Context, candidate Candidate) float64 {
+	return logScale(candidate.ChatCount, s.Saturation)
+}
+
+// WatchCompletion scores how much of the video viewers watch. Videos with
+// fewer than MinViews views get Prior instead, so a single view does not
+// decide the score.
+type WatchCompletion struct {
+	MinViews int
+	Prior    float64
+}
+
+func (s WatchCompletion) Name() string { return "watch_completion" }
+
+func (s WatchCompletion) Score(ctx Context, candidate Candidate) float64 {
+	if candidate.WatchCount < s.MinViews {
+		return s.Prior
+	}
+	return math.Max(0, math.Min(1, candidate.WatchCompletion))
+}
+
+// FollowAffinity scores 1 for videos of creators the viewer follows.
+type FollowAffinity struct{}
+
+func (s FollowAffinity) Name() string { return "follow_affinity" }
+
+func (s FollowAffinity) Score(ctx Context, candidate Candidate) float64 {
+	if candidate.ViewerFollowsUploader {
+		return 1
+	}
+	return 0
+}
+
+func logScale(count int, saturation int) float64 {
+	if count <= 0 || saturation <= 0 {
+		return 0
+	}
+	return math.Min(1, math.Log1p(float64(count))/math.Log1p(float64(saturation)))
+}
+
+// Balanced weighs freshness and engagement about equally.
+func Balanced() Ranker {
+	return Ranker{
+		Name: "balanced",
+		Scorers: []Weighted{
+			{Scorer: Recency{HalfLife: 24 * time.Hour}, Weight: 1},
+			{Scorer: Likes{Saturation: 1000}, Weight: 0.8},
+			{Scorer: Chat{Saturation: 500}, Weight: 0.4},
+			{Scorer: WatchCompletion{MinViews: 5, Prior: 0.5}, Weight: 0.8},
+			{Scorer: FollowAffinity{}, Weight: 0.5},
+		},
+	}
+}
+
+// Engagement favours videos people like, talk about and watch through over
+// fresh ones.
+func Engagement() Ranker {
+	return Ranker{
+		Name: "engagement",
+		Scorers: []Weighted{
+			{Scorer: Recency{HalfLife: 72 * time.Hour}, Weight: 0.5},
+			{Scorer: Likes{Saturation: 1000}, Weight: 1},
+			{Scorer: Chat{Saturation: 500}, Weight: 0.8},
+			{Scorer: WatchCompletion{MinViews: 5, Prior: 0.5}, Weight: 1.2},
+			{Scorer: FollowAffinity{}, Weight: 0.3},
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package ranking
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var fixtureNow = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestRecency(t *testing.T) {
+	scorer := Recency{HalfLife: 24 * time.Hour}
+	ages := map[time.Duration]float64{
+		0:               1,
+		24 * time.Hour:  0.5,
+		48 * time.Hour:  0.25,
+		-1 * time.Hour:  1, // uploaded after now, e.g. clock skew
+		240 * time.Hour: 1.0 / 1024,
+	}
+	for age, want := range ages {
+		got := scorer.Score(Context{Now: fixtureNow}, Candidate{UploadTime: fixtureNow.Add(-age)})
+		if !approxEqual(got, want) {
+			t.Errorf("age %v: score %v, want %v", age, got, want)
+		}
+	}
+}
+
+func TestLogScaleScorers(t *testing.T) {
+	likes := Likes{Saturation: 1000}
+	chat := Chat{Saturation: 1000}
+	counts := map[int]float64{
+		-1:    0,
+		0:     0,
+		1000:  1,
+		50000: 1,
+		31:    math.Log1p(31) / math.Log1p(1000),
+	}
+	for count, want := range counts {
+		if got := likes.Score(Context{}, Candidate{LikeCount: count}); !approxEqual(got, want) {
+			t.Errorf("likes %d: score %v, want %v", count, got, want)
+		}
+		if got := chat.Score(Context{}, Candidate{ChatCount: count}); !approxEqual(got, want) {
+			t.Errorf("chat %d: score %v, want %v", count, got, want)
+		}
+	}
+
+	if got := (Likes{}).Score(Context{}, Candidate{LikeCount: 10}); got != 0 {
+		t.Errorf("zero saturation: score %v, want 0", got)
+	}
+}
+
+func TestWatchCompletion(t *testing.T) {
+	scorer := WatchCompletion{MinViews: 5, Prior: 0.5}
+	candidates := []struct {
+		candidate Candidate
+		want      float64
+	}{
+		{Candidate{WatchCount: 4, WatchCompletion: 1}, 0.5},
+		{Candidate{WatchCount: 5, WatchCompletion: 0.8}, 0.8},
+		{Candidate{WatchCount: 100, WatchCompletion: 1.3}, 1},
+		{Candidate{WatchCount: 100, WatchCompletion: -0.1}, 0},
+	}
+	for _, c := range candidates {
+		if got := scorer.Score(Context{}, c.candidate); !approxEqual(got, c.want) {
+			t.Errorf("%+v: score %v, want %v", c.candidate, got, c.want)
+		}
+	}
+}
+
+func TestFollowAffinity(t *testing.T) {
+	if got := (FollowAffinity{}).Score(Context{}, Candidate{ViewerFollowsUploader: true}); got != 1 {
+		t.Errorf("followed uploader: score %v, want 1", got)
+	}
+	if got := (FollowAffinity{}).Score(Context{}, Candidate{}); got != 0 {
+		t.Errorf("other uploader: score %v, want 0", got)
+	}
+}